@@ -0,0 +1,187 @@
+package harvester
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/julianshen/go-readability"
+	"golang.org/x/net/html"
+)
+
+// Article is the readable content of an HTML page, with navigation, ads, comments
+// and other clutter removed
+type Article struct {
+	Title     string
+	HTML      string
+	Text      string
+	WordCount int
+	LeadImage string
+	Error     error
+}
+
+// blockElements are the elements that start a new line when converting HTML to plain text
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true,
+	"hr": true, "li": true, "main": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "tr": true, "ul": true,
+}
+
+// articleURLAttrs are the attributes whose relative URLs are resolved in an article's HTML
+var articleURLAttrs = map[string]bool{"href": true, "src": true, "poster": true}
+
+// ExtractArticle finds the readable article in an HTML page. The title is the one from the page's
+// metadata or, if there's none, the article's first heading; the lead image is the article's first
+// image or, if it has none, the image from the page's metadata. Relative URLs in the article's HTML
+// (links, images and so on) and its lead image are resolved against pageURL.
+func ExtractArticle(pageHTML []byte, pageURL *url.URL, metadata *PageMetadata) *Article {
+	result := new(Article)
+	doc, err := readability.NewDocument(string(pageHTML))
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	result.HTML = doc.Content()
+
+	articleDoc, err := html.Parse(strings.NewReader(result.HTML))
+	if err != nil {
+		result.Error = fmt.Errorf("unable to parse readable article HTML: %v", err)
+		return result
+	}
+	if pageURL != nil {
+		resolveArticleURLs(articleDoc, pageURL)
+		if result.HTML, err = renderArticleBody(articleDoc); err != nil {
+			result.Error = fmt.Errorf("unable to render readable article HTML: %v", err)
+			return result
+		}
+	}
+	result.Text = htmlToText(articleDoc)
+	result.WordCount = len(strings.Fields(result.Text))
+	if metadata != nil {
+		result.Title = metadata.Title
+	}
+	if len(result.Title) == 0 {
+		result.Title = findFirstHeading(articleDoc)
+	}
+	if leadImage := findFirstImage(articleDoc); len(leadImage) > 0 {
+		result.LeadImage = leadImage
+	} else if metadata != nil {
+		result.LeadImage = metadata.Image
+	}
+	return result
+}
+
+// resolveArticleURLs replaces the relative URLs in the article's attributes with absolute ones
+func resolveArticleURLs(n *html.Node, pageURL *url.URL) {
+	if n.Type == html.ElementNode {
+		for i, attr := range n.Attr {
+			if articleURLAttrs[strings.ToLower(attr.Key)] {
+				n.Attr[i].Val = resolveMetadataURL(strings.TrimSpace(attr.Val), pageURL)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		resolveArticleURLs(c, pageURL)
+	}
+}
+
+// renderArticleBody renders the contents of the parsed article's <body>, leaving out the
+// <html>, <head> and <body> elements html.Parse adds
+func renderArticleBody(doc *html.Node) (string, error) {
+	body := findElement(doc, "body")
+	if body == nil {
+		body = doc
+	}
+	var buffer bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&buffer, c); err != nil {
+			return "", err
+		}
+	}
+	return buffer.String(), nil
+}
+
+// findElement returns the first element in the document with the given name
+func findElement(n *html.Node, name string) *html.Node {
+	if n.Type == html.ElementNode && strings.EqualFold(n.Data, name) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findFirstHeading returns the whitespace-normalized text of the first <h1> to <h6> in the document
+func findFirstHeading(doc *html.Node) string {
+	for _, name := range []string{"h1", "h2", "h3", "h4", "h5", "h6"} {
+		if heading := findElement(doc, name); heading != nil {
+			return strings.Join(strings.Fields(nodeText(heading)), " ")
+		}
+	}
+	return ""
+}
+
+// htmlToText returns the text in the document with one paragraph (or other block) per line
+func htmlToText(doc *html.Node) string {
+	var lines []string
+	var line strings.Builder
+	endLine := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); len(text) > 0 {
+			lines = append(lines, text)
+		}
+		line.Reset()
+	}
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			line.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			switch strings.ToLower(n.Data) {
+			case "script", "style", "noscript", "template":
+				return
+			}
+		}
+
+		isBlock := n.Type == html.ElementNode && blockElements[strings.ToLower(n.Data)]
+		if isBlock {
+			endLine()
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+		if isBlock {
+			endLine()
+		}
+	}
+	f(doc)
+	endLine()
+	return strings.Join(lines, "\n\n")
+}
+
+// findFirstImage returns the src of the first <img> in the document
+func findFirstImage(doc *html.Node) string {
+	var src string
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if len(src) > 0 {
+			return
+		}
+		if n.Type == html.ElementNode && strings.EqualFold(n.Data, "img") {
+			src = strings.TrimSpace(getAttr(n, "src"))
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	return src
+}
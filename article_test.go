@@ -0,0 +1,88 @@
+package harvester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/html"
+)
+
+type ArticleSuite struct {
+	suite.Suite
+	pageURL *url.URL
+}
+
+func (suite *ArticleSuite) SetupSuite() {
+	suite.pageURL, _ = url.Parse("https://example.com/news/2018/harbour.html")
+}
+
+// extract extracts the article from a fixture with metadata parsed from the same page
+func (suite *ArticleSuite) extract(fileName string) (*Article, *PageMetadata) {
+	pageHTML, err := ioutil.ReadFile(fileName)
+	suite.NoError(err, "Fixture %s should exist", fileName)
+	doc, err := html.Parse(strings.NewReader(string(pageHTML)))
+	suite.NoError(err, "Fixture %s should be valid HTML", fileName)
+	metadata := ParsePageMetadata(doc, suite.pageURL)
+	return ExtractArticle(pageHTML, suite.pageURL, metadata), metadata
+}
+
+func (suite *ArticleSuite) TestExtracted() {
+	article, _ := suite.extract("testdata/article/story.html")
+	suite.NoError(article.Error)
+	suite.Equal(article.Title, "Harbour Wall Restored After Storm", "The metadata's title should be preferred")
+	suite.Contains(article.Text, "The old harbour wall, badly damaged in the winter storm")
+	suite.Contains(article.Text, "well ahead of schedule.")
+	suite.NotContains(article.Text, "All rights reserved", "Clutter should have been removed")
+	suite.Equal(article.WordCount, len(strings.Fields(article.Text)))
+	suite.True(article.WordCount > 100, "The article should have all its paragraphs")
+}
+
+func (suite *ArticleSuite) TestRelativeURLsResolved() {
+	article, _ := suite.extract("testdata/article/story.html")
+	suite.Equal(article.LeadImage, "https://example.com/news/images/harbour.jpg", "The article's first image should be resolved against the page URL")
+	suite.Contains(article.HTML, `src="https://example.com/news/images/harbour.jpg"`)
+	suite.Contains(article.HTML, `href="https://example.com/news/storm-damage.html"`)
+	suite.NotContains(article.HTML, "<body>", "Only the article should be rendered")
+}
+
+func (suite *ArticleSuite) TestFallbacks() {
+	article, metadata := suite.extract("testdata/article/brief.html")
+	suite.NoError(article.Error)
+	suite.Equal(article.LeadImage, metadata.Image, "The metadata's image should be used when the article has none")
+	suite.Equal(article.LeadImage, "https://cdn.example.com/brief.jpg")
+
+	pageHTML, _ := ioutil.ReadFile("testdata/article/brief.html")
+	article = ExtractArticle(pageHTML, suite.pageURL, nil)
+	suite.Equal(article.Title, "Ferry Timetable Changes", "The article's first heading should be used without metadata")
+	suite.Empty(article.LeadImage)
+}
+
+func (suite *ArticleSuite) TestHarvestedArticle() {
+	pageHTML, _ := ioutil.ReadFile("testdata/article/story.html")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(pageHTML)
+	}))
+	defer server.Close()
+
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	content := ch.HarvestResources(fmt.Sprintf("Read %s/news/harbour.html", server.URL)).Resources[0].ResourceContent()
+	suite.Nil(content.Article, "Articles should not be extracted by default")
+
+	ch.SetArticleExtraction(true)
+	content = ch.HarvestResources(fmt.Sprintf("Read %s/news/harbour.html", server.URL)).Resources[0].ResourceContent()
+	suite.NotNil(content.Article)
+	suite.Equal(content.Article.Title, "Harbour Wall Restored After Storm")
+	suite.Equal(content.Article.LeadImage, server.URL+"/images/harbour.jpg", "The lead image should be resolved against the harvested URL")
+}
+
+func TestArticleSuite(t *testing.T) {
+	suite.Run(t, new(ArticleSuite))
+}
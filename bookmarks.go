@@ -0,0 +1,330 @@
+package harvester
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Bookmark is a single bookmark from a Netscape bookmark file, the HTML format browsers such as
+// Chrome and Firefox import and export
+type Bookmark struct {
+	Title       string
+	URL         string
+	Folders     []*BookmarkFolder // the folders the bookmark is in, outermost first
+	AddDate     time.Time
+	Tags        []string // Firefox's tags
+	Description string   // the <DD> text that follows the bookmark, if any, without WriteBookmarks' flag
+}
+
+// BookmarkFolder is a folder (<H3>) in a Netscape bookmark file; the bookmarks in a folder share it
+type BookmarkFolder struct {
+	Name            string
+	AddDate         time.Time
+	LastModified    time.Time
+	PersonalToolbar bool // whether it's the browser's bookmarks bar (PERSONAL_TOOLBAR_FOLDER)
+}
+
+// HarvestedBookmark is a bookmark with the resource harvested from its URL; Resource is nil when the
+// URL isn't harvested, e.g. for javascript: bookmarklets, or when harvesting was cancelled
+type HarvestedBookmark struct {
+	*Bookmark
+	Resource *HarvestedResource
+}
+
+// Tags added to flagged bookmarks by WriteBookmarks
+const (
+	InvalidBookmarkTag    = "harvest:invalid"
+	IgnoredBookmarkTag    = "harvest:ignored"
+	RestrictedBookmarkTag = "harvest:restricted"
+	RetryableBookmarkTag  = "harvest:retryable"
+)
+
+// bookmarkTags are all the tags WriteBookmarks flags bookmarks with
+var bookmarkTags = map[string]bool{
+	InvalidBookmarkTag:    true,
+	IgnoredBookmarkTag:    true,
+	RestrictedBookmarkTag: true,
+	RetryableBookmarkTag:  true,
+}
+
+// bookmarkFlagRegEx matches the flag WriteBookmarks adds to the end of descriptions, e.g.
+// " [harvest:invalid: Invalid HTTP Status Code 404]", and any repeats of it; only complete flags at the
+// very end match, so the user's own text in brackets is kept
+var bookmarkFlagRegEx = regexp.MustCompile(`(?:\s*\[(?:` + regexp.QuoteMeta(InvalidBookmarkTag) + `|` + regexp.QuoteMeta(IgnoredBookmarkTag) +
+	`|` + regexp.QuoteMeta(RestrictedBookmarkTag) + `|` + regexp.QuoteMeta(RetryableBookmarkTag) + `): [^\]]*\])+$`)
+
+// bookmarkReasonReplacer keeps brackets in a reason, e.g. an IPv6 host in an error, from ending its flag early
+var bookmarkReasonReplacer = strings.NewReplacer("[", "(", "]", ")")
+
+// ParseBookmarks reads a Netscape bookmark file, where each folder is an <H3> followed by a <DL>
+// of its bookmarks (<DT><A>) and subfolders. Bookmarks are returned in the order they appear.
+func ParseBookmarks(reader io.Reader) ([]*Bookmark, error) {
+	var result []*Bookmark
+	var folders []*BookmarkFolder
+	var folderLists []bool // whether each open <DL> is a folder's, in which case it's in folders
+	var folder, pendingFolder *BookmarkFolder
+	var bookmark, described *Bookmark
+	var text strings.Builder
+
+	endDescription := func() {
+		if described != nil {
+			// the flag from an earlier export is removed so exporting again doesn't repeat it
+			described.Description = bookmarkFlagRegEx.ReplaceAllString(strings.TrimSpace(text.String()), "")
+			described = nil
+		}
+	}
+
+	tokenizer := html.NewTokenizer(bufio.NewReader(reader))
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				endDescription()
+				return result, nil
+			}
+			return nil, fmt.Errorf("unable to parse bookmarks: %v", tokenizer.Err())
+
+		case html.TextToken:
+			if folder != nil || bookmark != nil || described != nil {
+				text.Write(tokenizer.Text())
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "dt", "dd", "dl":
+				endDescription()
+			}
+			switch token.Data {
+			case "h3":
+				folder = &BookmarkFolder{
+					AddDate:         parseBookmarkDate(tokenAttr(token, "add_date")),
+					LastModified:    parseBookmarkDate(tokenAttr(token, "last_modified")),
+					PersonalToolbar: strings.EqualFold(tokenAttr(token, "personal_toolbar_folder"), "true"),
+				}
+				text.Reset()
+			case "dl":
+				folderLists = append(folderLists, pendingFolder != nil)
+				if pendingFolder != nil {
+					folders = append(folders, pendingFolder)
+					pendingFolder = nil
+				}
+			case "a":
+				bookmark = &Bookmark{
+					URL:     strings.TrimSpace(tokenAttr(token, "href")),
+					Folders: append([]*BookmarkFolder(nil), folders...),
+					AddDate: parseBookmarkDate(tokenAttr(token, "add_date")),
+				}
+				for _, tag := range strings.Split(tokenAttr(token, "tags"), ",") {
+					if tag = strings.TrimSpace(tag); len(tag) > 0 {
+						bookmark.Tags = append(bookmark.Tags, tag)
+					}
+				}
+				text.Reset()
+			case "dd":
+				if len(result) > 0 {
+					described = result[len(result)-1]
+					text.Reset()
+				}
+			}
+
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "h3":
+				if folder != nil {
+					folder.Name = strings.TrimSpace(text.String())
+					pendingFolder = folder
+					folder = nil
+				}
+			case "a":
+				if bookmark != nil {
+					bookmark.Title = strings.TrimSpace(text.String())
+					result = append(result, bookmark)
+					bookmark = nil
+				}
+			case "dl":
+				endDescription()
+				if len(folderLists) > 0 {
+					if folderLists[len(folderLists)-1] {
+						folders = folders[:len(folders)-1]
+					}
+					folderLists = folderLists[:len(folderLists)-1]
+				}
+				pendingFolder = nil
+			}
+		}
+	}
+}
+
+// parseBookmarkDate converts an ADD_DATE, which is seconds since the epoch (some browsers write
+// microseconds), to a time; it's the zero time if there's no date
+func parseBookmarkDate(text string) time.Time {
+	value, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	if err != nil || value <= 0 {
+		return time.Time{}
+	}
+	if value > 1e14 {
+		return time.Unix(0, value*int64(time.Microsecond)).UTC()
+	}
+	return time.Unix(value, 0).UTC()
+}
+
+// BookmarksContentSource returns the bookmarks' URLs for harvesting, each with its title as its
+// anchor text; bookmarks that aren't http or https, such as bookmarklets, are left out
+func BookmarksContentSource(bookmarks []*Bookmark) *ContentSource {
+	result := &ContentSource{Format: PlainTextContent, Provenance: &Provenance{Kind: BookmarksProvenance}, LinksOnly: true}
+	for _, bookmark := range bookmarks {
+		if urlText, ok := resolveDiscoveredURL(bookmark.URL, nil); ok {
+			result.Links = append(result.Links, &DiscoveredURL{Text: bookmark.URL, URL: urlText, AnchorText: bookmark.Title, Offset: -1, EmbedStyle: BookmarkEmbed})
+		}
+	}
+	return result
+}
+
+// HarvestBookmarks parses a Netscape bookmark file (see ParseBookmarks) and harvests every bookmark's
+// URL, so dead links can be found and tracking parameters removed before writing the bookmarks back
+// out with WriteBookmarks. Bookmarks with the same URL share a resource. If harvesting is cancelled
+// the bookmarks are returned with an error, and those that weren't harvested have no Resource.
+func (h *ContentHarvester) HarvestBookmarks(ctx context.Context, reader io.Reader) ([]*HarvestedBookmark, error) {
+	bookmarks, err := ParseBookmarks(reader)
+	if err != nil {
+		return nil, err
+	}
+	harvested := h.HarvestResourcesFromSource(ctx, BookmarksContentSource(bookmarks))
+
+	resources := make(map[string]*HarvestedResource)
+	for _, resource := range harvested.Resources {
+		for _, occurrence := range resource.Occurrences() {
+			resources[occurrence.Text] = resource
+		}
+	}
+	var result []*HarvestedBookmark
+	for _, bookmark := range bookmarks {
+		result = append(result, &HarvestedBookmark{Bookmark: bookmark, Resource: resources[bookmark.URL]})
+	}
+	if isCancelled, reason := harvested.IsCancelled(); isCancelled {
+		return result, fmt.Errorf("harvesting bookmarks was cancelled: %s", reason)
+	}
+	return result, nil
+}
+
+// exportedURL returns the URL the bookmark is written with, which is the harvested final URL, and
+// the tag and reason it's flagged with if its URL wasn't harvested (in which case it's kept)
+func (b *HarvestedBookmark) exportedURL() (string, string, string) {
+	if b.Resource == nil {
+		return b.URL, "", ""
+	}
+	isURLValid, isDestValid := b.Resource.IsValid()
+	isIgnored, ignoreReason := b.Resource.IsIgnored()
+	isRestricted, restrictedReason := b.Resource.IsRestricted()
+	isRetryable, retryableReason := b.Resource.IsRetryable()
+	_, _, statusReason := b.Resource.HTTPStatus()
+	switch {
+	case isRestricted:
+		return b.URL, RestrictedBookmarkTag, restrictedReason
+	case isRetryable:
+		return b.URL, RetryableBookmarkTag, retryableReason
+	case !isURLValid || !isDestValid:
+		if len(statusReason) > 0 {
+			return b.URL, InvalidBookmarkTag, statusReason
+		}
+		return b.URL, InvalidBookmarkTag, ignoreReason
+	case isIgnored:
+		return b.URL, IgnoredBookmarkTag, ignoreReason
+	}
+	finalURL, _, _ := b.Resource.GetURLs()
+	if finalURL == nil {
+		return b.URL, "", ""
+	}
+	return finalURL.String(), "", ""
+}
+
+// WriteBookmarks writes harvested bookmarks as a Netscape bookmark file, with each bookmark's final
+// URL in place of the one it was imported with. Bookmarks whose URL is invalid (e.g. a dead link),
+// ignored, restricted or retryable keep their URL and are flagged with one of the *BookmarkTag tags in
+// their TAGS, which Firefox imports, and the reason in their description. Folders are written, with their
+// dates and bookmarks bar attribute, as they're found in the bookmarks' Folders, so empty folders aren't kept.
+func WriteBookmarks(writer io.Writer, bookmarks []*HarvestedBookmark) error {
+	out := bufio.NewWriter(writer)
+	fmt.Fprint(out, "<!DOCTYPE NETSCAPE-Bookmark-file-1>\n"+
+		"<META HTTP-EQUIV=\"Content-Type\" CONTENT=\"text/html; charset=UTF-8\">\n"+
+		"<TITLE>Bookmarks</TITLE>\n"+
+		"<H1>Bookmarks</H1>\n"+
+		"<DL><p>\n")
+
+	var folders []*BookmarkFolder
+	indent := func() string {
+		return strings.Repeat("    ", len(folders)+1)
+	}
+	for _, bookmark := range bookmarks {
+		common := 0
+		for common < len(folders) && common < len(bookmark.Folders) && *folders[common] == *bookmark.Folders[common] {
+			common++
+		}
+		for len(folders) > common {
+			folders = folders[:len(folders)-1]
+			fmt.Fprintf(out, "%s</DL><p>\n", indent())
+		}
+		for _, folder := range bookmark.Folders[common:] {
+			fmt.Fprintf(out, "%s<DT><H3", indent())
+			if !folder.AddDate.IsZero() {
+				fmt.Fprintf(out, " ADD_DATE=\"%d\"", folder.AddDate.Unix())
+			}
+			if !folder.LastModified.IsZero() {
+				fmt.Fprintf(out, " LAST_MODIFIED=\"%d\"", folder.LastModified.Unix())
+			}
+			if folder.PersonalToolbar {
+				fmt.Fprint(out, " PERSONAL_TOOLBAR_FOLDER=\"true\"")
+			}
+			fmt.Fprintf(out, ">%s</H3>\n%s<DL><p>\n", html.EscapeString(folder.Name), indent())
+			folders = append(folders, folder)
+		}
+
+		urlText, flag, reason := bookmark.exportedURL()
+		fmt.Fprintf(out, "%s<DT><A HREF=\"%s\"", indent(), html.EscapeString(urlText))
+		if !bookmark.AddDate.IsZero() {
+			fmt.Fprintf(out, " ADD_DATE=\"%d\"", bookmark.AddDate.Unix())
+		}
+		// flags from an earlier export are replaced
+		var tags []string
+		for _, tag := range bookmark.Tags {
+			if !bookmarkTags[tag] {
+				tags = append(tags, tag)
+			}
+		}
+		if len(flag) > 0 {
+			tags = append(tags, flag)
+		}
+		if len(tags) > 0 {
+			fmt.Fprintf(out, " TAGS=\"%s\"", html.EscapeString(strings.Join(tags, ",")))
+		}
+		fmt.Fprintf(out, ">%s</A>\n", html.EscapeString(bookmark.Title))
+
+		description := bookmark.Description
+		if len(reason) > 0 {
+			if len(description) > 0 {
+				description += " "
+			}
+			description += fmt.Sprintf("[%s: %s]", flag, bookmarkReasonReplacer.Replace(reason))
+		}
+		if len(description) > 0 {
+			fmt.Fprintf(out, "%s<DD>%s\n", indent(), html.EscapeString(description))
+		}
+	}
+	for len(folders) > 0 {
+		folders = folders[:len(folders)-1]
+		fmt.Fprintf(out, "%s</DL><p>\n", indent())
+	}
+	fmt.Fprint(out, "</DL><p>\n")
+	return out.Flush()
+}
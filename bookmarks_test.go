@@ -0,0 +1,135 @@
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BookmarksSuite struct {
+	fixtureSuite
+}
+
+// folderNames returns the names of a bookmark's folders, outermost first
+func folderNames(bookmark *Bookmark) []string {
+	var result []string
+	for _, folder := range bookmark.Folders {
+		result = append(result, folder.Name)
+	}
+	return result
+}
+
+func (suite *BookmarksSuite) TestParseBookmarks() {
+	bookmarks, err := ParseBookmarks(strings.NewReader(suite.fixture("testdata/bookmarks/bookmarks.html")))
+	suite.NoError(err)
+	suite.Equal(len(bookmarks), 5)
+
+	article := bookmarks[0]
+	suite.Equal(article.Title, "Tom & Jerry's article", "Entities should be unescaped")
+	suite.Equal(article.URL, suite.server.URL+"/article?utm_source=newsletter&id=3")
+	suite.Equal(folderNames(article), []string{"Bookmarks bar"})
+	bar := article.Folders[0]
+	suite.True(bar.PersonalToolbar)
+	suite.Equal(bar.AddDate, time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC))
+	suite.Equal(bar.LastModified, time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC))
+	suite.Equal(article.AddDate, time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC))
+	suite.Equal(article.Tags, []string{"reading", "news"})
+	suite.Equal(article.Description, "Read this weekend")
+
+	suite.Equal(folderNames(bookmarks[1]), []string{"Bookmarks bar", "Tools"})
+	suite.True(bookmarks[1].Folders[0] == bar, "Bookmarks in the same folder should share it")
+	suite.False(bookmarks[1].Folders[1].PersonalToolbar)
+	suite.True(bookmarks[1].Folders[1].LastModified.IsZero())
+	suite.Equal(bookmarks[1].Description, "Still useful", "Flags from earlier exports should be removed")
+	suite.Equal(bookmarks[2].URL, "javascript:alert('bookmarklet')")
+	suite.Equal(folderNames(bookmarks[3]), []string{"Bookmarks bar"}, "Closing a subfolder should return to its parent")
+	suite.Empty(bookmarks[4].Folders)
+	suite.Equal(bookmarks[4].AddDate, time.Date(2018, 6, 3, 12, 0, 0, 0, time.UTC), "Microsecond dates should be recognized")
+}
+
+func (suite *BookmarksSuite) TestUserBracketsKept() {
+	bookmarks, err := ParseBookmarks(strings.NewReader(`<DL><p>
+    <DT><A HREF="https://example.com/1">One</A>
+    <DD>My [notes] [harvest:invalid: Invalid HTTP Status Code 404] [harvest:retryable: Temporarily unavailable]
+    <DT><A HREF="https://example.com/2">Two</A>
+    <DD>[harvest:ignored: Ignored] see [this] later
+    <DT><A HREF="https://example.com/3">Three</A>
+    <DD>Checked [harvest:invalid: Invalid HTTP Status Code 404] [my own note]
+</DL><p>`))
+	suite.NoError(err)
+	suite.Equal(len(bookmarks), 3)
+	suite.Equal(bookmarks[0].Description, "My [notes]", "Only the trailing flags should be removed")
+	suite.Equal(bookmarks[1].Description, "[harvest:ignored: Ignored] see [this] later", "Flags followed by the user's text should be kept")
+	suite.Equal(bookmarks[2].Description, "Checked [harvest:invalid: Invalid HTTP Status Code 404] [my own note]", "The user's text in brackets should be kept")
+}
+
+func (suite *BookmarksSuite) TestHarvestAndWriteBookmarks() {
+	ignoreRule := ignoreURLsRegExList{regexp.MustCompile(`/ignored$`)}
+	ch := MakeContentHarvester(nil, ignoreRule, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	harvested, err := ch.HarvestBookmarks(context.Background(), strings.NewReader(suite.fixture("testdata/bookmarks/bookmarks.html")))
+	suite.NoError(err)
+	suite.Equal(len(harvested), 5)
+	suite.NotNil(harvested[0].Resource)
+	suite.True(harvested[0].Resource == harvested[4].Resource, "Bookmarks with the same URL should share a resource")
+	suite.Nil(harvested[2].Resource, "Bookmarklets should not be harvested")
+
+	var exported bytes.Buffer
+	suite.NoError(WriteBookmarks(&exported, harvested))
+	bookmarks, err := ParseBookmarks(&exported)
+	suite.NoError(err, "The exported bookmarks should be readable")
+	suite.Equal(len(bookmarks), 5)
+
+	suite.Equal(bookmarks[0].URL, suite.server.URL+"/article?id=3", "The final URL should be exported")
+	suite.Equal(bookmarks[0].Title, "Tom & Jerry's article")
+	suite.Equal(bookmarks[0].Tags, []string{"reading", "news"})
+	suite.Equal(bookmarks[0].AddDate, time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC))
+	suite.Equal(bookmarks[0].Description, "Read this weekend")
+
+	gone := bookmarks[1]
+	suite.Equal(gone.URL, suite.server.URL+"/missing", "Invalid bookmarks should keep their URL")
+	suite.Equal(folderNames(gone), []string{"Bookmarks bar", "Tools"})
+	suite.Equal(gone.Tags, []string{InvalidBookmarkTag})
+	suite.Equal(gone.Description, "Still useful")
+
+	suite.Equal(bookmarks[2].URL, "javascript:alert('bookmarklet')")
+	suite.Empty(bookmarks[2].Tags)
+	suite.Equal(bookmarks[3].Tags, []string{IgnoredBookmarkTag})
+	suite.Equal(folderNames(bookmarks[3]), []string{"Bookmarks bar"})
+	suite.True(bookmarks[3].Folders[0].PersonalToolbar, "The bookmarks bar should still be the bookmarks bar")
+	suite.Equal(bookmarks[3].Folders[0].LastModified, time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC))
+	suite.Empty(bookmarks[4].Folders)
+
+	harvested[1].Tags = gone.Tags
+	exported.Reset()
+	suite.NoError(WriteBookmarks(&exported, harvested))
+	suite.Equal(strings.Count(exported.String(), InvalidBookmarkTag), 2, "Flags from an earlier export should be replaced, not repeated in the tags")
+}
+
+func (suite *BookmarksSuite) TestRoundTrip() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	export := func(input string) string {
+		harvested, err := ch.HarvestBookmarks(context.Background(), strings.NewReader(input))
+		suite.NoError(err)
+		var exported bytes.Buffer
+		suite.NoError(WriteBookmarks(&exported, harvested))
+		return exported.String()
+	}
+
+	first := export(suite.fixture("testdata/bookmarks/bookmarks.html"))
+	suite.Contains(first, "<DD>Still useful [harvest:invalid: Invalid HTTP Status Code 404]\n")
+	suite.Contains(first, `<DT><H3 ADD_DATE="1527811200" LAST_MODIFIED="1527811200" PERSONAL_TOOLBAR_FOLDER="true">Bookmarks bar</H3>`)
+	suite.Contains(first, `<DT><H3 ADD_DATE="1527811200">Tools</H3>`)
+	suite.Equal(strings.Count(first, "[harvest:"), 1, "Only the invalid bookmark should be flagged, once")
+	suite.Equal(export(first), first, "Exporting again should not change the bookmarks")
+}
+
+func TestBookmarksSuite(t *testing.T) {
+	suite.Run(t, new(BookmarksSuite))
+}
@@ -0,0 +1,141 @@
+package harvester
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// CanonicalURLPolicy decides what happens to the canonical URL a page declares for itself
+type CanonicalURLPolicy int
+
+const (
+	// IgnoreCanonicalURL doesn't look for a canonical URL at all
+	IgnoreCanonicalURL CanonicalURLPolicy = iota
+
+	// RecordCanonicalURL records the canonical URL on the resource but leaves finalURL alone
+	RecordCanonicalURL
+
+	// PreferCanonicalURL records the canonical URL and makes it the resource's finalURL (and
+	// its content's URL). The ignore and clean rules apply to the canonical URL just as they do
+	// to the resolved URL, so an ignored canonical URL is only recorded.
+	PreferCanonicalURL
+)
+
+// Sources of canonical URLs, in order of precedence
+const (
+	CanonicalLinkElement = "<link rel=\"canonical\">"
+	CanonicalLinkHeader  = "Link: rel=\"canonical\" header"
+	CanonicalOpenGraph   = "og:url"
+)
+
+// findCanonicalURL looks for the canonical URL declared by a <link rel="canonical"> element, then
+// a 'Link: <...>; rel="canonical"' HTTP header and finally an og:url meta property; relative
+// URLs are resolved against the page's base URL. It returns the URL and which source it came from.
+func findCanonicalURL(resp *http.Response, doc *html.Node, pageURL *url.URL) (*url.URL, string) {
+	baseURL := pageURL
+	var linkHref, ogURL string
+	if doc != nil {
+		baseURL = findBaseURL(doc, pageURL)
+		linkHref, ogURL = findCanonicalTags(doc)
+	}
+
+	if len(linkHref) > 0 {
+		if canonicalURL, err := resolveURL(linkHref, baseURL); err == nil {
+			return canonicalURL, CanonicalLinkElement
+		}
+	}
+	for _, headerValue := range resp.Header["Link"] {
+		if headerHref, found := findCanonicalLinkHeader(headerValue); found {
+			if canonicalURL, err := resolveURL(headerHref, pageURL); err == nil {
+				return canonicalURL, CanonicalLinkHeader
+			}
+		}
+	}
+	if len(ogURL) > 0 {
+		if canonicalURL, err := resolveURL(ogURL, baseURL); err == nil {
+			return canonicalURL, CanonicalOpenGraph
+		}
+	}
+	return nil, ""
+}
+
+// findCanonicalTags returns the first <link rel="canonical"> href and og:url content in the document
+func findCanonicalTags(doc *html.Node) (string, string) {
+	var linkHref, ogURL string
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch strings.ToLower(n.Data) {
+			case "link":
+				if len(linkHref) == 0 && hasRelation(getAttr(n, "rel"), "canonical") {
+					linkHref = strings.TrimSpace(getAttr(n, "href"))
+				}
+			case "meta":
+				if len(ogURL) == 0 && strings.EqualFold(strings.TrimSpace(getAttr(n, "property")), "og:url") {
+					ogURL = strings.TrimSpace(getAttr(n, "content"))
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	return linkHref, ogURL
+}
+
+// findCanonicalLinkHeader parses an RFC 8288 Link header value such as
+//
+//	<https://example.com/article>; rel="canonical", <https://example.com/a>; rel="shortlink"
+//
+// and returns the target of the link whose relation type is canonical
+func findCanonicalLinkHeader(headerValue string) (string, bool) {
+	for len(headerValue) > 0 {
+		start := strings.IndexByte(headerValue, '<')
+		end := strings.IndexByte(headerValue, '>')
+		if start < 0 || end < start {
+			return "", false
+		}
+		target := headerValue[start+1 : end]
+		params := headerValue[end+1:]
+		if next := strings.IndexByte(params, '<'); next >= 0 {
+			headerValue = params[next:]
+			params = params[:next]
+		} else {
+			headerValue = ""
+		}
+
+		for _, param := range strings.Split(params, ";") {
+			nameValue := strings.SplitN(param, "=", 2)
+			if len(nameValue) == 2 && strings.EqualFold(strings.TrimSpace(nameValue[0]), "rel") {
+				if hasRelation(strings.Trim(strings.TrimSpace(nameValue[1]), `",`), "canonical") {
+					return strings.TrimSpace(target), true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// hasRelation returns true if the space-separated list of link relation types includes relType
+func hasRelation(rel string, relType string) bool {
+	for _, r := range strings.Fields(rel) {
+		if strings.EqualFold(r, relType) {
+			return true
+		}
+	}
+	return false
+}
+
+// getAttr returns the value of the named attribute, or an empty string if it's not present
+func getAttr(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, name) {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+// DiscordMessage is a single message from a Discord channel export. Content is Discord's Markdown,
+// so links may be bare, <suppressed> or [masked](https://example.com); Embeds are the message's
+// link previews.
+type DiscordMessage struct {
+	ID        string
+	GuildID   string
+	Guild     string
+	ChannelID string
+	Channel   string
+	Author    string
+	Timestamp time.Time
+	Content   string
+	Embeds    []*DiscoveredURL
+}
+
+// discordExportJSON is a channel exported as JSON by DiscordChatExporter
+type discordExportJSON struct {
+	Guild struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"guild"`
+	Channel struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	Messages []struct {
+		ID        string `json:"id"`
+		Timestamp string `json:"timestamp"`
+		Content   string `json:"content"`
+		Author    struct {
+			Name     string `json:"name"`
+			Nickname string `json:"nickname"`
+		} `json:"author"`
+		Embeds []struct {
+			Title string `json:"title"`
+			URL   string `json:"url"`
+		} `json:"embeds"`
+	} `json:"messages"`
+}
+
+// discordDirectMessagesGuildID is the guild ID DiscordChatExporter gives direct messages
+const discordDirectMessagesGuildID = "0"
+
+// ParseDiscordExport reads a Discord channel exported as JSON by DiscordChatExporter, which has the
+// guild (server), the channel and its messages in the order they were posted
+func ParseDiscordExport(reader io.Reader) ([]*DiscordMessage, error) {
+	var export discordExportJSON
+	if err := json.NewDecoder(reader).Decode(&export); err != nil {
+		return nil, fmt.Errorf("unable to parse Discord export: %v", err)
+	}
+
+	var result []*DiscordMessage
+	for _, message := range export.Messages {
+		parsed := &DiscordMessage{
+			ID:        message.ID,
+			GuildID:   export.Guild.ID,
+			Guild:     export.Guild.Name,
+			ChannelID: export.Channel.ID,
+			Channel:   export.Channel.Name,
+			Author:    message.Author.Nickname,
+			Content:   message.Content,
+		}
+		if len(parsed.Author) == 0 {
+			parsed.Author = message.Author.Name
+		}
+		if timestamp, err := time.Parse(time.RFC3339, message.Timestamp); err == nil {
+			parsed.Timestamp = timestamp
+		}
+		for _, embed := range message.Embeds {
+			if urlText, ok := resolveDiscoveredURL(embed.URL, nil); ok {
+				parsed.Embeds = append(parsed.Embeds, &DiscoveredURL{Text: embed.URL, URL: urlText, AnchorText: embed.Title, Offset: -1, EmbedStyle: ChatUnfurlEmbed})
+			}
+		}
+		result = append(result, parsed)
+	}
+	return result, nil
+}
+
+// SourceURL returns the message's link, which Discord only opens for members of the channel
+func (m *DiscordMessage) SourceURL() *url.URL {
+	if len(m.ChannelID) == 0 || len(m.ID) == 0 {
+		return nil
+	}
+	guildID := m.GuildID
+	if len(guildID) == 0 || guildID == discordDirectMessagesGuildID {
+		guildID = "@me"
+	}
+	result, _ := url.Parse(fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, m.ChannelID, m.ID))
+	return result
+}
+
+// ContentSource returns the message's content for harvesting as Markdown, with its provenance; its
+// embeds are the source's links, so they're harvested after the URLs found in the content
+func (m *DiscordMessage) ContentSource() *ContentSource {
+	return &ContentSource{
+		Content: m.Content,
+		Format:  MarkdownContent,
+		Provenance: &Provenance{
+			Kind:      ChatMessageProvenance,
+			SourceID:  m.ID,
+			Author:    m.Author,
+			Channel:   m.Channel,
+			Timestamp: m.Timestamp,
+			SourceURL: m.SourceURL(),
+		},
+		Links: m.Embeds,
+	}
+}
+
+// HarvestDiscordExport parses a DiscordChatExporter JSON export (see ParseDiscordExport) and harvests
+// each message's links and embeds, using the harvester's URL discovery for the message content. It
+// returns one HarvestedResources per message (like every adapter, messages without any URLs are
+// included so the results line up with ParseDiscordExport's) and stops at the first message that's
+// cancelled.
+func (h *ContentHarvester) HarvestDiscordExport(ctx context.Context, reader io.Reader) ([]*HarvestedResources, error) {
+	messages, err := ParseDiscordExport(reader)
+	if err != nil {
+		return nil, err
+	}
+	var result []*HarvestedResources
+	for _, message := range messages {
+		harvested := h.HarvestResourcesFromSource(ctx, message.ContentSource())
+		result = append(result, harvested)
+		if isCancelled, _ := harvested.IsCancelled(); isCancelled {
+			break
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,73 @@
+package harvester
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DiscordSuite struct {
+	fixtureSuite
+}
+
+func (suite *DiscordSuite) TestParseDiscordExport() {
+	messages, err := ParseDiscordExport(strings.NewReader(suite.fixture("testdata/discord/general.json")))
+	suite.NoError(err)
+	suite.Equal(len(messages), 2)
+
+	message := messages[0]
+	suite.Equal(message.Guild, "Example Server")
+	suite.Equal(message.Channel, "general")
+	suite.Equal(message.Author, "Jane", "The nickname should be preferred")
+	suite.True(message.Timestamp.Equal(time.Date(2018, 6, 1, 12, 0, 0, 123000000, time.UTC)))
+	suite.Equal(len(message.Embeds), 2)
+	suite.Equal(message.SourceURL().String(), "https://discord.com/channels/81384788765712384/381870553235193857/381870553235193858")
+	suite.Equal(messages[1].Author, "sam")
+
+	_, err = ParseDiscordExport(strings.NewReader(`{"messages": [`))
+	suite.Error(err)
+}
+
+func (suite *DiscordSuite) TestHarvestDiscordExport() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	harvested, err := ch.HarvestDiscordExport(context.Background(), strings.NewReader(suite.fixture("testdata/discord/general.json")))
+	suite.NoError(err)
+	suite.Equal(len(harvested), 2, "There should be one result per message")
+	suite.Empty(harvested[1].Resources, "Messages without URLs should have no resources")
+
+	hrs := harvested[0]
+	suite.Equal(hrs.Provenance.Kind, ChatMessageProvenance)
+	suite.Equal(hrs.Provenance.Channel, "general")
+	suite.Equal(hrs.Provenance.Author, "Jane")
+	suite.Equal(len(hrs.Resources), 4, "Bare, masked and suppressed links and embeds should be harvested, but not code")
+
+	bare := hrs.Resources[0]
+	suite.Equal(bare.OriginalURLText(), suite.server.URL+"/bare")
+	suite.Equal(len(bare.Occurrences()), 2, "An embed of a link in the content should be another occurrence of it")
+	suite.Equal(hrs.Resources[1].AnchorText(), "the docs")
+	suite.Equal(hrs.Resources[1].Provenance().EmbedStyle, MarkdownLinkEmbed)
+	suite.Equal(hrs.Resources[2].Provenance().EmbedStyle, MarkdownAutolinkEmbed)
+	suite.Equal(hrs.Resources[3].Provenance().EmbedStyle, ChatUnfurlEmbed)
+	suite.Equal(hrs.Resources[3].AnchorText(), "Preview only")
+}
+
+func (suite *DiscordSuite) TestHarvesterDiscoverySettings() {
+	messages, err := ParseDiscordExport(strings.NewReader(suite.fixture("testdata/discord/general.json")))
+	suite.NoError(err)
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	ch.SetURLDiscovery(URLDiscoveryConfig{AllowedSchemes: []string{"https"}})
+	hrs := ch.HarvestResourcesFromSource(context.Background(), messages[0].ContentSource())
+	suite.Equal(len(hrs.Resources), 2, "Only the embeds should be harvested since the content's http URLs aren't allowed")
+	for _, hr := range hrs.Resources {
+		suite.Equal(hr.Provenance().EmbedStyle, ChatUnfurlEmbed)
+	}
+}
+
+func TestDiscordSuite(t *testing.T) {
+	suite.Run(t, new(DiscordSuite))
+}
@@ -0,0 +1,604 @@
+package harvester
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+	"mvdan.cc/xurls"
+)
+
+// ContentFormat tells the harvester how the content it's given is marked up, which
+// decides where URLs are discovered
+type ContentFormat int
+
+const (
+	// PlainTextContent finds URLs anywhere in the text (this is the default)
+	PlainTextContent ContentFormat = iota
+
+	// HTMLContent finds URLs in <a href>, <img src> and <link href> only, so URLs in
+	// scripts, styles and other attributes aren't harvested
+	HTMLContent
+
+	// MarkdownContent finds URLs in inline links and images, reference definitions and
+	// <autolinks>, plus plain URLs outside of links; code spans and blocks are skipped
+	MarkdownContent
+)
+
+// URLDiscoveryMode decides how plain text is recognized as a URL
+type URLDiscoveryMode int
+
+const (
+	// RelaxedURLDiscovery finds URLs with or without a scheme, e.g. "example.com/page" (this is the default)
+	RelaxedURLDiscovery URLDiscoveryMode = iota
+
+	// StrictURLDiscovery only finds URLs that have a scheme, e.g. "https://example.com/page"
+	StrictURLDiscovery
+)
+
+// URLDiscoveryConfig decides which URLs are discovered in content. The zero value is relaxed discovery
+// of URLs with any scheme and no extra validation or trimming.
+type URLDiscoveryConfig struct {
+	// Mode chooses strict or relaxed matching of URLs in plain text (including plain text in Markdown)
+	Mode URLDiscoveryMode
+
+	// AllowedSchemes, if not empty, limits discovered URLs to these schemes (e.g. "https"); relaxed
+	// URLs without a scheme are treated as "http"
+	AllowedSchemes []string
+
+	// ValidateTLD requires each URL's host to be an IP address or end in a suffix from the public
+	// suffix list bundled with golang.org/x/net/publicsuffix, so text like "https://t" isn't fetched
+	ValidateTLD bool
+
+	// TrimPunctuation removes trailing punctuation (e.g. the period ending a sentence) and unbalanced
+	// closing brackets (e.g. "(see https://example.com/page)") from URLs matched in plain text
+	TrimPunctuation bool
+}
+
+// Discoverer finds the URLs in content; SetDiscoverer replaces the harvester's built-in discovery
+// with a custom one. URLs must be returned in the order they appear in the content.
+type Discoverer interface {
+	DiscoverURLs(source *ContentSource) []*DiscoveredURL
+}
+
+// urlDiscoverer is the built-in Discoverer
+type urlDiscoverer struct {
+	config URLDiscoveryConfig
+	regEx  *regexp.Regexp
+}
+
+// MakeURLDiscoverer prepares the built-in Discoverer, which custom discoverers may wrap
+func MakeURLDiscoverer(config URLDiscoveryConfig) Discoverer {
+	result := new(urlDiscoverer)
+	result.config = config
+	result.regEx = xurls.Relaxed
+	if config.Mode == StrictURLDiscovery {
+		result.regEx = xurls.Strict
+	}
+	return result
+}
+
+// ContentSource is content to be harvested along with how it's marked up. BaseURL, if not
+// nil, is used to resolve relative URLs found in HTML and Markdown links (an HTML <base href>
+// takes precedence); relative URLs that can't be resolved are harvested as-is and will be invalid.
+// Provenance, if not nil, says where the content came from.
+//
+// Links are URLs an adapter has already found: outside of the content (e.g. a feed item's link or a
+// chat message's unfurls, with an Offset of -1) or in it (e.g. a tweet's entities, which locate the
+// t.co links in its text). They're harvested after the URLs discovered in the content. When LinksOnly
+// is true the Links are all of the source's URLs, so the content isn't searched.
+type ContentSource struct {
+	Content    string
+	Format     ContentFormat
+	BaseURL    *url.URL
+	Provenance *Provenance
+	Links      []*DiscoveredURL
+	LinksOnly  bool
+}
+
+// DiscoveredURL is a single occurrence of a URL found in content. Discoverers fill in Offset; the
+// harvester then fills in RuneOffset, Line and Context (see SetOccurrenceContextWidth).
+type DiscoveredURL struct {
+	Text       string // the URL exactly as it appears in the content, e.g. a relative href
+	URL        string // the URL that's harvested, which is Text resolved against the base URL
+	AnchorText string // the link text (or image alt text) for HTML, Markdown and chat links
+	Offset     int    // the byte offset of Text in the content
+	RuneOffset int    // the rune (character) offset of Text in the content
+	Line       int    // the line number, starting at 1, that Text is on
+	Context    string // the content surrounding Text, with whitespace collapsed
+	EmbedStyle EmbedStyle
+}
+
+// markdownCodeRegEx matches fenced code blocks and code spans, which aren't scanned for URLs
+var markdownCodeRegEx = regexp.MustCompile("(?s)```.*?```|~~~.*?~~~|`[^`\n]+`")
+
+// markdownAutolinkRegEx matches <scheme:...> autolinks
+var markdownAutolinkRegEx = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9+.\-]{1,31}:[^<>\s]*)>`)
+
+// markdownReferenceRegEx matches link reference definitions like [label]: https://example.com "Title"
+var markdownReferenceRegEx = regexp.MustCompile(`(?m)^ {0,3}\[([^\]]+)\]:[ \t]*<?([^\s>]+)>?`)
+
+// markdownImageRegEx matches images inside link text, which are replaced by their alt text
+var markdownImageRegEx = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+
+// DiscoverURLs finds all URLs in the source, in the order they appear
+func (d *urlDiscoverer) DiscoverURLs(source *ContentSource) []*DiscoveredURL {
+	switch source.Format {
+	case HTMLContent:
+		return d.discoverHTMLURLs(source.Content, source.BaseURL)
+	case MarkdownContent:
+		return d.discoverMarkdownURLs(source.Content, source.BaseURL)
+	default:
+		return d.discoverPlainTextURLs(source.Content, source.Content)
+	}
+}
+
+// discoverPlainTextURLs finds every URL the regular expression matches in searchText, which is
+// content with anything that shouldn't be searched blanked out. These aren't resolved against a
+// base URL since text like "example.com" would become a path.
+func (d *urlDiscoverer) discoverPlainTextURLs(content string, searchText string) []*DiscoveredURL {
+	var result []*DiscoveredURL
+	for _, loc := range d.regEx.FindAllStringIndex(searchText, -1) {
+		urlText := content[loc[0]:loc[1]]
+		if d.config.TrimPunctuation {
+			urlText = trimURLPunctuation(urlText)
+		}
+		if len(urlText) > 0 && d.accept(urlText) {
+			result = append(result, &DiscoveredURL{Text: urlText, URL: urlText, Offset: loc[0], EmbedStyle: PlainTextEmbed})
+		}
+	}
+	return result
+}
+
+// accept checks a discovered URL against the allowed schemes and TLD validation
+func (d *urlDiscoverer) accept(urlText string) bool {
+	if len(d.config.AllowedSchemes) == 0 && !d.config.ValidateTLD {
+		return true
+	}
+	if !strings.Contains(urlText, "://") && !strings.HasPrefix(strings.ToLower(urlText), "mailto:") {
+		urlText = "http://" + urlText
+	}
+	parsed, err := url.Parse(urlText)
+	if err != nil {
+		// unparseable URLs are kept so they're reported as invalid
+		return true
+	}
+
+	if len(d.config.AllowedSchemes) > 0 {
+		allowed := false
+		for _, scheme := range d.config.AllowedSchemes {
+			if strings.EqualFold(parsed.Scheme, scheme) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	if d.config.ValidateTLD && len(parsed.Host) > 0 {
+		return isValidHost(parsed.Hostname())
+	}
+	return true
+}
+
+// isValidHost returns true if host is an IP address or a domain under a known public suffix
+func isValidHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	suffix, icann := publicsuffix.PublicSuffix(host)
+	if suffix == host {
+		// a bare suffix like "com" isn't a site
+		return false
+	}
+	// unlisted TLDs get a default rule, which is the last label with icann false
+	return icann || strings.Contains(suffix, ".")
+}
+
+// trimURLPunctuation removes trailing punctuation and unbalanced closing brackets, which
+// are far more likely to belong to the surrounding text than to the URL
+func trimURLPunctuation(urlText string) string {
+	for len(urlText) > 0 {
+		last := urlText[len(urlText)-1]
+		switch last {
+		case '.', ',', ':', ';', '!', '?', '\'', '"', '*':
+		case ')', ']', '}':
+			opening := map[byte]string{')': "(", ']': "[", '}': "{"}[last]
+			if strings.Count(urlText, opening) >= strings.Count(urlText, string(last)) {
+				return urlText
+			}
+		default:
+			return urlText
+		}
+		urlText = urlText[:len(urlText)-1]
+	}
+	return urlText
+}
+
+// resolveDiscoveredURL resolves a URL found in markup against the base URL, returning false for
+// links that don't lead anywhere harvestable such as fragments, mailto: or javascript:
+func resolveDiscoveredURL(urlText string, baseURL *url.URL) (string, bool) {
+	urlText = strings.TrimSpace(urlText)
+	if len(urlText) == 0 || strings.HasPrefix(urlText, "#") {
+		return "", false
+	}
+	resolved, err := resolveURL(urlText, baseURL)
+	if err != nil || !resolved.IsAbs() {
+		return urlText, true
+	}
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return "", false
+	}
+	return resolved.String(), true
+}
+
+// discoverHTMLURLs tokenizes HTML and finds the URLs in <a href>, <img src> and <link href>; the
+// anchor text is the text inside <a> (or the alt text of the images in it) and the alt text for <img>
+func (d *urlDiscoverer) discoverHTMLURLs(content string, baseURL *url.URL) []*DiscoveredURL {
+	var result []*DiscoveredURL
+	var anchor *DiscoveredURL
+	var anchorText, anchorAlt strings.Builder
+	endAnchor := func() {
+		if anchor == nil {
+			return
+		}
+		anchor.AnchorText = strings.Join(strings.Fields(anchorText.String()), " ")
+		if len(anchor.AnchorText) == 0 {
+			anchor.AnchorText = strings.Join(strings.Fields(anchorAlt.String()), " ")
+		}
+		anchor = nil
+		anchorText.Reset()
+		anchorAlt.Reset()
+	}
+	add := func(offset int, urlText string, embedStyle EmbedStyle) *DiscoveredURL {
+		resolved, ok := resolveDiscoveredURL(urlText, baseURL)
+		if !ok || !d.accept(resolved) {
+			return nil
+		}
+		discovered := &DiscoveredURL{Text: urlText, URL: resolved, Offset: offset, EmbedStyle: embedStyle}
+		result = append(result, discovered)
+		return discovered
+	}
+
+	z := html.NewTokenizer(strings.NewReader(content))
+	offset := 0
+	foundBase := false
+	for {
+		tokenType := z.Next()
+		if tokenType == html.ErrorToken {
+			// the error is io.EOF at the end of the content; anything else can't be recovered from
+			endAnchor()
+			break
+		}
+		tokenOffset := offset
+		raw := string(z.Raw())
+		offset += len(raw)
+		token := z.Token()
+		// URLs are located at their attribute's value unless it was escaped (e.g. &amp;)
+		valueOffset := func(name, value string) int {
+			if index := rawAttrValueIndex(raw, name); index >= 0 && strings.HasPrefix(raw[index:], value) {
+				return tokenOffset + index
+			}
+			return tokenOffset
+		}
+
+		switch tokenType {
+		case html.TextToken:
+			if anchor != nil {
+				anchorText.WriteString(token.Data)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.Data {
+			case "base":
+				if href := tokenAttr(token, "href"); !foundBase && len(href) > 0 {
+					foundBase = true
+					if resolved, err := resolveURL(strings.TrimSpace(href), baseURL); err == nil {
+						baseURL = resolved
+					}
+				}
+			case "a":
+				endAnchor()
+				if href, ok := tokenHasAttr(token, "href"); ok {
+					anchor = add(valueOffset("href", href), href, HTMLAnchorEmbed)
+				}
+			case "img":
+				alt := tokenAttr(token, "alt")
+				if anchor != nil {
+					anchorAlt.WriteString(" " + alt)
+				}
+				if src, ok := tokenHasAttr(token, "src"); ok {
+					if discovered := add(valueOffset("src", src), src, HTMLImageEmbed); discovered != nil {
+						discovered.AnchorText = strings.Join(strings.Fields(alt), " ")
+					}
+				}
+			case "link":
+				if href, ok := tokenHasAttr(token, "href"); ok {
+					add(valueOffset("href", href), href, HTMLLinkEmbed)
+				}
+			}
+		case html.EndTagToken:
+			if token.Data == "a" {
+				endAnchor()
+			}
+		}
+	}
+	return result
+}
+
+// rawAttrValueIndex returns where the value of the first attribute with the given (lower case)
+// name starts in a raw start tag, or -1 if the tag doesn't have it. Attributes are scanned the way
+// the HTML tokenizer reads them so that the same text in another attribute isn't mistaken for it.
+func rawAttrValueIndex(raw, name string) int {
+	isSpace := func(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' }
+	i := 1 // skip the tag name after the <
+	for i < len(raw) && !isSpace(raw[i]) && raw[i] != '/' && raw[i] != '>' {
+		i++
+	}
+	for i < len(raw) {
+		for i < len(raw) && (isSpace(raw[i]) || raw[i] == '/') {
+			i++
+		}
+		if i >= len(raw) || raw[i] == '>' {
+			return -1
+		}
+		nameStart := i
+		i++
+		for i < len(raw) && !isSpace(raw[i]) && raw[i] != '/' && raw[i] != '>' && raw[i] != '=' {
+			i++
+		}
+		attrName := strings.ToLower(raw[nameStart:i])
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		if i >= len(raw) || raw[i] != '=' {
+			continue
+		}
+		i++
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		valueStart, valueEnd := i, i
+		if i < len(raw) && (raw[i] == '"' || raw[i] == '\'') {
+			valueStart++
+			valueEnd = strings.IndexByte(raw[valueStart:], raw[i])
+			if valueEnd < 0 {
+				valueEnd = len(raw)
+			} else {
+				valueEnd += valueStart
+			}
+			i = valueEnd + 1
+		} else {
+			for valueEnd < len(raw) && !isSpace(raw[valueEnd]) && raw[valueEnd] != '>' {
+				valueEnd++
+			}
+			i = valueEnd
+		}
+		if attrName == name {
+			return valueStart
+		}
+	}
+	return -1
+}
+
+// tokenHasAttr returns the value of the named attribute and whether the token has it
+func tokenHasAttr(token html.Token, name string) (string, bool) {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// tokenAttr returns the value of the named attribute, or an empty string if it's not present
+func tokenAttr(token html.Token, name string) string {
+	value, _ := tokenHasAttr(token, name)
+	return value
+}
+
+// discoverMarkdownURLs finds the URLs in Markdown links, images, reference definitions and
+// autolinks, then any plain URLs the regular expression matches outside of those
+func (d *urlDiscoverer) discoverMarkdownURLs(content string, baseURL *url.URL) []*DiscoveredURL {
+	// masked is the content with code and links blanked out, keeping the same offsets
+	masked := []byte(content)
+	mask := func(start, end int) {
+		for i := start; i < end; i++ {
+			if masked[i] != '\n' {
+				masked[i] = ' '
+			}
+		}
+	}
+	for _, loc := range markdownCodeRegEx.FindAllStringIndex(content, -1) {
+		mask(loc[0], loc[1])
+	}
+
+	var result []*DiscoveredURL
+	add := func(offset int, urlText string, anchorText string, embedStyle EmbedStyle) {
+		if resolved, ok := resolveDiscoveredURL(urlText, baseURL); ok && d.accept(resolved) {
+			result = append(result, &DiscoveredURL{Text: urlText, URL: resolved, AnchorText: anchorText, Offset: offset, EmbedStyle: embedStyle})
+		}
+	}
+
+	// links are the spans masked once every link is found; destinations are where each link's URL
+	// is, since a destination written as <https://...> would also match as an autolink
+	var links, destinations [][2]int
+	text := string(masked)
+	for _, loc := range markdownReferenceRegEx.FindAllStringSubmatchIndex(text, -1) {
+		add(loc[4], text[loc[4]:loc[5]], markdownLinkText(text[loc[2]:loc[3]]), MarkdownReferenceEmbed)
+		links = append(links, [2]int{loc[0], loc[1]})
+		destinations = append(destinations, [2]int{loc[4], loc[5]})
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] != '[' || (i > 0 && text[i-1] == '\\') {
+			continue
+		}
+		closeBracket := findMarkdownClosingBracket(text, i)
+		if closeBracket < 0 || closeBracket+1 >= len(text) || text[closeBracket+1] != '(' {
+			continue
+		}
+		destStart, destEnd, end := parseMarkdownDestination(text, closeBracket+2)
+		if end < 0 {
+			continue
+		}
+		start, embedStyle := i, MarkdownLinkEmbed
+		if i > 0 && text[i-1] == '!' {
+			start, embedStyle = i-1, MarkdownImageEmbed
+		}
+		// keep scanning inside the link text since it may contain an image
+		add(destStart, text[destStart:destEnd], markdownLinkText(text[i+1:closeBracket]), embedStyle)
+		links = append(links, [2]int{start, end})
+		destinations = append(destinations, [2]int{destStart, destEnd})
+	}
+autolinks:
+	for _, loc := range markdownAutolinkRegEx.FindAllStringSubmatchIndex(text, -1) {
+		for _, destination := range destinations {
+			if loc[2] >= destination[0] && loc[3] <= destination[1] {
+				continue autolinks
+			}
+		}
+		add(loc[2], text[loc[2]:loc[3]], "", MarkdownAutolinkEmbed)
+		links = append(links, [2]int{loc[0], loc[1]})
+	}
+	for _, link := range links {
+		mask(link[0], link[1])
+	}
+
+	result = append(result, d.discoverPlainTextURLs(content, string(masked))...)
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Offset < result[j].Offset })
+	return result
+}
+
+// findMarkdownClosingBracket returns the index of the ] matching the [ at start, or -1
+func findMarkdownClosingBracket(text string, start int) int {
+	depth := 0
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case '\n':
+			// links don't span paragraphs
+			if i+1 < len(text) && text[i+1] == '\n' {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+// parseMarkdownDestination parses the "url "title")" following a link's "](", returning where the
+// URL starts and ends and the index after the closing parenthesis (or -1 if it's not a valid link)
+func parseMarkdownDestination(text string, pos int) (int, int, int) {
+	skipSpace := func(i int) int {
+		for i < len(text) && (text[i] == ' ' || text[i] == '\t' || text[i] == '\n') {
+			i++
+		}
+		return i
+	}
+
+	pos = skipSpace(pos)
+	if pos >= len(text) {
+		return 0, 0, -1
+	}
+	var destStart, destEnd int
+	if text[pos] == '<' {
+		closeAngle := strings.IndexAny(text[pos+1:], ">\n")
+		if closeAngle < 0 || text[pos+1+closeAngle] != '>' {
+			return 0, 0, -1
+		}
+		destStart, destEnd = pos+1, pos+1+closeAngle
+		pos = destEnd + 1
+	} else {
+		// parentheses in the URL must be balanced, which is how the link's closing one is told apart
+		destStart = pos
+		depth := 0
+	dest:
+		for ; pos < len(text); pos++ {
+			switch c := text[pos]; {
+			case c == '\\':
+				pos++
+			case c == '(':
+				depth++
+			case c == ')':
+				if depth == 0 {
+					break dest
+				}
+				depth--
+			case c <= ' ':
+				break dest
+			}
+		}
+		destEnd = pos
+	}
+	if destEnd <= destStart || destEnd > len(text) {
+		return 0, 0, -1
+	}
+
+	pos = skipSpace(pos)
+	if pos < len(text) && (text[pos] == '"' || text[pos] == '\'' || text[pos] == '(') {
+		closing := text[pos]
+		if closing == '(' {
+			closing = ')'
+		}
+		closeTitle := strings.IndexByte(text[pos+1:], closing)
+		if closeTitle < 0 {
+			return 0, 0, -1
+		}
+		pos = skipSpace(pos + 1 + closeTitle + 1)
+	}
+	if pos >= len(text) || text[pos] != ')' {
+		return 0, 0, -1
+	}
+	return destStart, destEnd, pos + 1
+}
+
+// markdownLinkText turns a link's Markdown text into plain text
+func markdownLinkText(text string) string {
+	text = markdownImageRegEx.ReplaceAllString(text, "$1")
+	text = strings.NewReplacer("*", "", "__", "", "~~", "", "`", "", "\\", "").Replace(text)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// locateDiscoveredURL fills in where an occurrence is in the content from its byte offset,
+// with up to contextWidth runes of content on either side as its context
+func locateDiscoveredURL(content string, discovered *DiscoveredURL, contextWidth int) {
+	if discovered.Offset < 0 || discovered.Offset > len(content) {
+		return
+	}
+	before := content[:discovered.Offset]
+	discovered.RuneOffset = utf8.RuneCountInString(before)
+	discovered.Line = strings.Count(before, "\n") + 1
+	if contextWidth <= 0 {
+		return
+	}
+
+	end := discovered.Offset
+	if strings.HasPrefix(content[end:], discovered.Text) {
+		end += len(discovered.Text)
+	}
+	start := discovered.Offset
+	for i := 0; i < contextWidth && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(content[:start])
+		start -= size
+	}
+	for i := 0; i < contextWidth && end < len(content); i++ {
+		_, size := utf8.DecodeRuneInString(content[end:])
+		end += size
+	}
+	discovered.Context = strings.Join(strings.Fields(content[start:end]), " ")
+}
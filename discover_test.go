@@ -0,0 +1,184 @@
+package harvester
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DiscoverSuite struct {
+	suite.Suite
+	baseURL *url.URL
+}
+
+func (suite *DiscoverSuite) SetupSuite() {
+	suite.baseURL, _ = url.Parse("https://example.com/blog/post.html")
+}
+
+func (suite *DiscoverSuite) discover(content string, format ContentFormat, baseURL *url.URL) []*DiscoveredURL {
+	return MakeURLDiscoverer(URLDiscoveryConfig{}).DiscoverURLs(&ContentSource{Content: content, Format: format, BaseURL: baseURL})
+}
+
+func (suite *DiscoverSuite) urls(discovered []*DiscoveredURL) []string {
+	var result []string
+	for _, d := range discovered {
+		result = append(result, d.URL)
+	}
+	return result
+}
+
+func (suite *DiscoverSuite) TestHTML() {
+	content := `<html><head>
+<link rel="alternate" href="/feed.xml">
+<script>var tracker = "https://tracker.example.net/script.js";</script>
+<style>body { background: url(https://cdn.example.net/bg.png) }</style>
+</head><body>
+<p>Read <a href="../about.html">about <b>us</b></a> and <a href="https://other.example.org/page">the &amp; other page</a>.</p>
+<p><a href="#top">Top</a> <a href="mailto:someone@example.com">Mail</a> <a href="javascript:void(0)">Nothing</a></p>
+<a href="/photos"><img src="thumb.jpg" alt="Holiday photos"></a>
+<p data-url="https://attribute.example.net/">Not a link</p>
+</body></html>`
+
+	discovered := suite.discover(content, HTMLContent, suite.baseURL)
+	suite.Equal([]string{
+		"https://example.com/feed.xml",
+		"https://example.com/about.html",
+		"https://other.example.org/page",
+		"https://example.com/photos",
+		"https://example.com/blog/thumb.jpg",
+	}, suite.urls(discovered), "Only link, a and img URLs should be discovered, resolved against the base URL")
+	suite.Equal("../about.html", discovered[1].Text, "The original href should be kept")
+	suite.Equal("about us", discovered[1].AnchorText)
+	suite.Equal("the & other page", discovered[2].AnchorText)
+	suite.Equal("Holiday photos", discovered[3].AnchorText, "An image-only link's anchor text is the image's alt text")
+	suite.Equal("Holiday photos", discovered[4].AnchorText)
+}
+
+func (suite *DiscoverSuite) TestHTMLBaseElement() {
+	content := `<head><base href="https://static.example.net/assets/"></head><body><a href="logo.png">Logo</a></body>`
+	discovered := suite.discover(content, HTMLContent, suite.baseURL)
+	suite.Equal([]string{"https://static.example.net/assets/logo.png"}, suite.urls(discovered))
+
+	discovered = suite.discover(`<a href="/relative">Relative</a>`, HTMLContent, nil)
+	suite.Equal([]string{"/relative"}, suite.urls(discovered), "Relative URLs without a base URL should be kept as-is")
+}
+
+func (suite *DiscoverSuite) TestMarkdown() {
+	content := "See [the docs](https://example.com/docs_(v2)) (and [this *page*](/page \"Title\")).\n" +
+		"[![Build status](https://ci.example.net/badge.svg)](https://ci.example.net/builds)\n" +
+		"Visit <https://auto.example.org/link> or https://bare.example.org/path today\n" +
+		"`https://code.example.org/span` is code\n" +
+		"```\nhttps://code.example.org/block\n```\n" +
+		"[ref]: https://ref.example.org/def \"Reference\"\n"
+
+	discovered := suite.discover(content, MarkdownContent, suite.baseURL)
+	suite.Equal([]string{
+		"https://example.com/docs_(v2)",
+		"https://example.com/page",
+		"https://ci.example.net/badge.svg",
+		"https://ci.example.net/builds",
+		"https://auto.example.org/link",
+		"https://bare.example.org/path",
+		"https://ref.example.org/def",
+	}, suite.urls(discovered), "Parentheses should be balanced and code skipped")
+	suite.Equal("the docs", discovered[0].AnchorText)
+	suite.Equal("this page", discovered[1].AnchorText)
+	suite.Equal("Build status", discovered[2].AnchorText)
+	suite.Equal("Build status", discovered[3].AnchorText, "Images in link text should be replaced by their alt text")
+	suite.Equal("ref", discovered[6].AnchorText)
+
+	content = "Read [the guide](<https://example.com/guide>) and <https://example.com/faq>\n" +
+		"[angled]: <https://example.com/angled>\n"
+	discovered = suite.discover(content, MarkdownContent, nil)
+	suite.Equal([]string{
+		"https://example.com/guide",
+		"https://example.com/faq",
+		"https://example.com/angled",
+	}, suite.urls(discovered), "Angle-bracketed link destinations should not also be reported as autolinks")
+	suite.Equal(discovered[0].EmbedStyle, MarkdownLinkEmbed)
+	suite.Equal(discovered[0].AnchorText, "the guide")
+	suite.Equal(discovered[1].EmbedStyle, MarkdownAutolinkEmbed)
+	suite.Equal(discovered[2].EmbedStyle, MarkdownReferenceEmbed)
+}
+
+func (suite *DiscoverSuite) TestOccurrencePosition() {
+	content := "First line\nCafé ☕ https://example.com/menu is open\nthird"
+	discovered := suite.discover(content, PlainTextContent, nil)[0]
+	locateDiscoveredURL(content, discovered, 8)
+	suite.Equal(discovered.Offset, 21, "The byte offset counts the multi-byte runes")
+	suite.Equal(discovered.RuneOffset, 18)
+	suite.Equal(discovered.Line, 2)
+	suite.Equal(discovered.Context, "Café ☕ https://example.com/menu is open", "The context should be 8 runes each side, whitespace collapsed")
+
+	locateDiscoveredURL(content, discovered, 0)
+	suite.Equal(discovered.Line, 2)
+
+	content = "<p>\n  See <a href=\"https://example.com/a?x=1&amp;y=2\">escaped</a> and <a href=\"https://example.com/b\">plain</a></p>"
+	occurrences := suite.discover(content, HTMLContent, nil)
+	suite.Equal(occurrences[0].Offset, 10, "Escaped attribute values are located at their tag")
+	suite.Equal(occurrences[1].Offset, 79, "Attribute values are located in their tag")
+	suite.Equal(content[79:79+len(occurrences[1].Text)], "https://example.com/b")
+
+	content = `<a data-x="https://example.com/c" title='Read https://example.com/c' HREF = "https://example.com/c">c</a><img alt=src src=https://example.com/c>`
+	occurrences = suite.discover(content, HTMLContent, nil)
+	suite.Equal(len(occurrences), 2)
+	suite.Equal(occurrences[0].Offset, strings.Index(content, `"https://example.com/c">`)+1, "The value should be located in the href attribute, not others with the same text")
+	suite.Equal(occurrences[1].Offset, strings.LastIndex(content, "https://example.com/c"), "Unquoted values should be located too")
+}
+
+func (suite *DiscoverSuite) TestStrictDiscovery() {
+	content := "Edit file.go for v1.2 of https://example.com/repo or see example.org/docs"
+	relaxed := MakeURLDiscoverer(URLDiscoveryConfig{Mode: RelaxedURLDiscovery}).DiscoverURLs(&ContentSource{Content: content})
+	suite.Contains(suite.urls(relaxed), "example.org/docs", "Relaxed discovery should find URLs without a scheme")
+
+	strict := MakeURLDiscoverer(URLDiscoveryConfig{Mode: StrictURLDiscovery}).DiscoverURLs(&ContentSource{Content: content})
+	suite.Equal([]string{"https://example.com/repo"}, suite.urls(strict), "Strict discovery should require a scheme")
+}
+
+func (suite *DiscoverSuite) TestAllowedSchemes() {
+	content := "Download ftp://files.example.com/archive.zip or https://example.com/archive.zip or example.org/archive.zip"
+	config := URLDiscoveryConfig{AllowedSchemes: []string{"HTTP", "https"}}
+	discovered := MakeURLDiscoverer(config).DiscoverURLs(&ContentSource{Content: content})
+	suite.Equal([]string{"https://example.com/archive.zip", "example.org/archive.zip"}, suite.urls(discovered), "Schemes should be compared case-insensitively and missing schemes treated as http")
+
+	content = `<a href="ftp://files.example.com/archive.zip">FTP</a> <a href="https://example.com/archive.zip">HTTPS</a>`
+	discovered = MakeURLDiscoverer(URLDiscoveryConfig{AllowedSchemes: []string{"ftp"}}).DiscoverURLs(&ContentSource{Content: content, Format: HTMLContent})
+	suite.Empty(discovered, "Markup links are limited to http and https as well as the allowed schemes")
+}
+
+func (suite *DiscoverSuite) TestValidateTLD() {
+	content := "Invalid https://t and https://localhost:8080/admin and https://com/ but valid https://example.co.uk/page, https://blog.github.io/ and http://192.168.1.10/status"
+	discovered := MakeURLDiscoverer(URLDiscoveryConfig{ValidateTLD: true, TrimPunctuation: true}).DiscoverURLs(&ContentSource{Content: content})
+	suite.Equal([]string{"https://example.co.uk/page", "https://blog.github.io/", "http://192.168.1.10/status"}, suite.urls(discovered))
+}
+
+func (suite *DiscoverSuite) TestTrimPunctuation() {
+	content := "Is it https://example.com/page? Yes (see https://example.com/wiki/Go_(language)), or https://example.com/list]."
+	trimmed := MakeURLDiscoverer(URLDiscoveryConfig{TrimPunctuation: true}).DiscoverURLs(&ContentSource{Content: content})
+	suite.Equal([]string{
+		"https://example.com/page",
+		"https://example.com/wiki/Go_(language)",
+		"https://example.com/list",
+	}, suite.urls(trimmed), "Trailing punctuation and unbalanced brackets should be trimmed, balanced ones kept")
+}
+
+func (suite *DiscoverSuite) TestCustomDiscoverer() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetDiscoverer(fixedDiscoverer{})
+	hrs := ch.HarvestResources("https://example.com/not-discovered")
+	suite.Equal(len(hrs.Resources), 1)
+	suite.Equal(hrs.Resources[0].OriginalURLText(), "not a URL")
+}
+
+// fixedDiscoverer always discovers the same (invalid) URL so harvesting doesn't need the network
+type fixedDiscoverer struct{}
+
+func (fixedDiscoverer) DiscoverURLs(source *ContentSource) []*DiscoveredURL {
+	return []*DiscoveredURL{{Text: "not a URL", URL: "not a URL"}}
+}
+
+func TestDiscoverSuite(t *testing.T) {
+	suite.Run(t, new(DiscoverSuite))
+}
@@ -0,0 +1,323 @@
+package harvester
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// EmailMessage is an e-mail parsed from an RFC 822 (.eml) file or an mbox. Parts are the
+// message's text/plain and text/html bodies, decoded; anything else is an attachment.
+type EmailMessage struct {
+	From        string
+	Subject     string
+	Date        time.Time
+	MessageID   string
+	Parts       []*EmailPart
+	Attachments []*EmailAttachment
+}
+
+// EmailPart is a decoded text/plain or text/html body of an e-mail
+type EmailPart struct {
+	MediaType string
+	Content   string
+}
+
+// EmailAttachment is any part of an e-mail that isn't a text/plain or text/html body
+type EmailAttachment struct {
+	FileName  string
+	MediaType string
+	Charset   string // the charset of text attachments, if given
+	ContentID string
+	Data      []byte
+}
+
+// emailTextFormats are the formats of the bodies and text attachments whose URLs are harvested
+var emailTextFormats = map[string]ContentFormat{
+	"text/plain":      PlainTextContent,
+	"text/html":       HTMLContent,
+	"text/markdown":   MarkdownContent,
+	"text/x-markdown": MarkdownContent,
+}
+
+// emailHeader is implemented by both message and MIME part headers
+type emailHeader interface {
+	Get(key string) string
+}
+
+// emailWordDecoder decodes RFC 2047 encoded words (e.g. =?UTF-8?Q?...?=) in headers
+var emailWordDecoder = new(mime.WordDecoder)
+
+// ParseEmail reads a single RFC 822 message, such as an .eml file, and walks its MIME parts.
+// When a multipart/alternative has several bodies only the last (richest) one is kept, since
+// the others have the same links.
+func ParseEmail(reader io.Reader) (*EmailMessage, error) {
+	message, err := mail.ReadMessage(bufio.NewReader(reader))
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(EmailMessage)
+	result.Subject = decodeEmailHeader(message.Header.Get("Subject"))
+	result.MessageID = strings.Trim(strings.TrimSpace(message.Header.Get("Message-ID")), "<>")
+	if date, err := message.Header.Date(); err == nil {
+		result.Date = date
+	}
+	if from, err := mail.ParseAddress(message.Header.Get("From")); err == nil {
+		result.From = from.Address
+		if len(from.Name) > 0 {
+			result.From = fmt.Sprintf("%s <%s>", from.Name, from.Address)
+		}
+	} else {
+		result.From = decodeEmailHeader(message.Header.Get("From"))
+	}
+
+	if err := result.addPart(message.Header, message.Body); err != nil {
+		return nil, fmt.Errorf("unable to read message %q: %v", result.MessageID, err)
+	}
+	return result, nil
+}
+
+// ParseMbox reads every message in an mbox, where each message starts with a "From " line;
+// lines in messages that started with "From " are expected to be escaped as ">From "
+func ParseMbox(reader io.Reader) ([]*EmailMessage, error) {
+	var result []*EmailMessage
+	var message bytes.Buffer
+	parse := func() error {
+		if message.Len() == 0 {
+			return nil
+		}
+		parsed, err := ParseEmail(&message)
+		if err != nil {
+			return fmt.Errorf("unable to parse message %d in mbox: %v", len(result)+1, err)
+		}
+		result = append(result, parsed)
+		message.Reset()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	previousBlank := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if previousBlank && strings.HasPrefix(line, "From ") {
+			if err := parse(); err != nil {
+				return nil, err
+			}
+			previousBlank = false
+			continue
+		}
+		if unescaped := strings.TrimLeft(line, ">"); len(unescaped) < len(line) && strings.HasPrefix(unescaped, "From ") {
+			line = line[1:]
+		}
+		message.WriteString(line)
+		message.WriteString("\r\n")
+		previousBlank = len(line) == 0
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := parse(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ParseEmails reads either an mbox (if it starts with a "From " line) or a single message
+func ParseEmails(reader io.Reader) ([]*EmailMessage, error) {
+	buffered := bufio.NewReader(reader)
+	if start, _ := buffered.Peek(5); string(start) == "From " {
+		return ParseMbox(buffered)
+	}
+	message, err := ParseEmail(buffered)
+	if err != nil {
+		return nil, err
+	}
+	return []*EmailMessage{message}, nil
+}
+
+// addPart walks a MIME part, adding its bodies and attachments to the message
+func (m *EmailMessage) addPart(header emailHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// RFC 2045 says parts without a valid Content-Type are plain US-ASCII text
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		var alternative *EmailMessage
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if mediaType != "multipart/alternative" {
+				if err := m.addPart(part.Header, part); err != nil {
+					return err
+				}
+				continue
+			}
+			candidate := new(EmailMessage)
+			if err := candidate.addPart(part.Header, part); err != nil {
+				return err
+			}
+			if len(candidate.Parts) > 0 || alternative == nil {
+				alternative = candidate
+			}
+		}
+		if alternative != nil {
+			m.Parts = append(m.Parts, alternative.Parts...)
+			m.Attachments = append(m.Attachments, alternative.Attachments...)
+		}
+		return nil
+	}
+
+	// multipart.Part decodes quoted-printable itself and removes the header
+	var decoded io.Reader = body
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		decoded = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		decoded = quotedprintable.NewReader(body)
+	}
+	data, err := ioutil.ReadAll(decoded)
+	if err != nil {
+		return err
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if disposition != "attachment" && (mediaType == "text/plain" || mediaType == "text/html") {
+		m.Parts = append(m.Parts, &EmailPart{MediaType: mediaType, Content: decodeCharset(data, params["charset"])})
+		return nil
+	}
+
+	attachment := &EmailAttachment{MediaType: mediaType, Charset: params["charset"], Data: data}
+	attachment.FileName = dispositionParams["filename"]
+	if len(attachment.FileName) == 0 {
+		attachment.FileName = params["name"]
+	}
+	attachment.ContentID = strings.Trim(strings.TrimSpace(header.Get("Content-ID")), "<>")
+	m.Attachments = append(m.Attachments, attachment)
+	return nil
+}
+
+// decodeEmailHeader decodes RFC 2047 encoded words, returning the header as-is if it can't
+func decodeEmailHeader(value string) string {
+	decoded, err := emailWordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// windows1252Runes are the characters Windows-1252 has in place of ISO-8859-1's C1 controls
+// (0x80 to 0x9F); the five bytes it doesn't define are kept as the controls
+var windows1252Runes = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// decodeCharset converts Windows-1252 and ISO-8859-1 to UTF-8; other charsets, which are nearly
+// always UTF-8 or US-ASCII, are kept as-is. Like browsers, ISO-8859-1 is decoded as Windows-1252
+// since text labelled ISO-8859-1 with bytes from 0x80 to 0x9F is really Windows-1252 (e.g. smart
+// quotes, dashes and €).
+func decodeCharset(data []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			if b >= 0x80 && b <= 0x9F {
+				runes[i] = windows1252Runes[b-0x80]
+			} else {
+				runes[i] = rune(b)
+			}
+		}
+		return string(runes)
+	}
+	return string(data)
+}
+
+// SourceURL returns the message's RFC 2392 mid: URL, or nil if it has no Message-ID
+func (m *EmailMessage) SourceURL() *url.URL {
+	if len(m.MessageID) == 0 {
+		return nil
+	}
+	return &url.URL{Scheme: "mid", Opaque: url.PathEscape(m.MessageID)}
+}
+
+// ContentSources returns each of the message's bodies, followed by its text attachments (plain text,
+// HTML and Markdown), for harvesting with the message's provenance. Other attachments, such as PDFs
+// and images, have no URLs to harvest; see DownloadedContent to inspect them.
+func (m *EmailMessage) ContentSources() []*ContentSource {
+	var result []*ContentSource
+	add := func(content string, format ContentFormat) {
+		result = append(result, &ContentSource{
+			Content: content,
+			Format:  format,
+			Provenance: &Provenance{
+				Kind:      EmailProvenance,
+				SourceID:  m.MessageID,
+				Title:     m.Subject,
+				Author:    m.From,
+				Timestamp: m.Date,
+				SourceURL: m.SourceURL(),
+			},
+		})
+	}
+	for _, part := range m.Parts {
+		add(part.Content, emailTextFormats[part.MediaType])
+	}
+	for _, attachment := range m.Attachments {
+		if format, ok := emailTextFormats[attachment.MediaType]; ok {
+			add(decodeCharset(attachment.Data, attachment.Charset), format)
+		}
+	}
+	return result
+}
+
+// DownloadedContent saves the attachment to a temporary file as if it had been downloaded, so it
+// can be inspected like harvested content; its URL is the attachment's RFC 2392 cid: URL, if any
+func (a *EmailAttachment) DownloadedContent() *DownloadedContent {
+	var contentURL *url.URL
+	if len(a.ContentID) > 0 {
+		contentURL = &url.URL{Scheme: "cid", Opaque: url.PathEscape(a.ContentID)}
+	}
+	return saveDownloadedContent(contentURL, bytes.NewReader(a.Data))
+}
+
+// HarvestEmails parses .eml or mbox input (see ParseEmails) and harvests the URLs in each message's
+// bodies and text attachments (see ContentSources), returning one HarvestedResources per body or
+// attachment. Harvesting stops at the first one that's cancelled.
+func (h *ContentHarvester) HarvestEmails(ctx context.Context, reader io.Reader) ([]*HarvestedResources, error) {
+	messages, err := ParseEmails(reader)
+	if err != nil {
+		return nil, err
+	}
+	var result []*HarvestedResources
+	for _, message := range messages {
+		for _, source := range message.ContentSources() {
+			harvested := h.HarvestResourcesFromSource(ctx, source)
+			result = append(result, harvested)
+			if isCancelled, _ := harvested.IsCancelled(); isCancelled {
+				return result, nil
+			}
+		}
+	}
+	return result, nil
+}
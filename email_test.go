@@ -0,0 +1,95 @@
+package harvester
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EmailSuite struct {
+	fixtureSuite
+}
+
+func (suite *EmailSuite) TestParseEmail() {
+	message, err := ParseEmail(strings.NewReader(suite.fixture("testdata/email/newsletter.eml")))
+	suite.NoError(err)
+	suite.Equal(message.From, "Café News <news@example.com>")
+	suite.Equal(message.Subject, "Weekly ☕ digest", "Encoded words should be decoded")
+	suite.Equal(message.MessageID, "digest-42@example.com")
+	suite.True(message.Date.Equal(time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)))
+	suite.Equal(message.SourceURL().String(), "mid:digest-42@example.com")
+
+	suite.Equal(len(message.Parts), 1, "Only the richest multipart/alternative body should be kept")
+	suite.Equal(message.Parts[0].MediaType, "text/html")
+	suite.Contains(message.Parts[0].Content, `<a href="`+suite.server.URL+`/story?utm_source=newsletter">the story</a> — enjoy!`, "Quoted-printable should be decoded")
+
+	suite.Equal(len(message.Attachments), 2)
+	suite.Equal(message.Attachments[0].FileName, "sources.txt")
+	suite.Equal(message.Attachments[0].Charset, "windows-1252")
+	attachment := message.Attachments[1]
+	suite.Equal(attachment.FileName, "digest.pdf")
+	suite.Equal(attachment.MediaType, "application/pdf")
+	suite.True(strings.HasPrefix(string(attachment.Data), "%PDF-1.4"), "Base64 should be decoded")
+
+	downloaded := attachment.DownloadedContent()
+	defer downloaded.Delete()
+	suite.NoError(downloaded.DownloadError)
+	suite.Equal(downloaded.URL.String(), "cid:digest-pdf@example.com")
+	suite.Equal(downloaded.FileType.Extension, "pdf", "The attachment's file type should be detected")
+	_, err = os.Stat(downloaded.DestPath)
+	suite.NoError(err, "The attachment should have been saved")
+}
+
+func (suite *EmailSuite) TestParseMbox() {
+	messages, err := ParseEmails(strings.NewReader(suite.fixture("testdata/email/archive.mbox")))
+	suite.NoError(err)
+	suite.Equal(len(messages), 2)
+
+	suite.Equal(messages[0].From, "Plain Sender <plain@example.com>")
+	suite.Equal(messages[0].Parts[0].Content, "See https://example.com/base64-link today.\n", "Base64 bodies should be decoded")
+	suite.True(messages[0].Date.Equal(time.Date(2018, 6, 2, 6, 30, 0, 0, time.UTC)))
+
+	suite.Equal(messages[1].From, "latin@example.com")
+	suite.Equal(messages[1].Parts[0].Content, "Café https://example.com/latin\r\nFrom the archive\r\n", "ISO-8859-1 should be converted and >From unescaped")
+}
+
+func (suite *EmailSuite) TestDecodeCharset() {
+	suite.Equal(decodeCharset([]byte("\x93Caf\xe9\x94 \x96 \x80"+"5"), "windows-1252"), "“Café” – €5")
+	suite.Equal(decodeCharset([]byte("\x85 \x81"), "ISO-8859-1"), "… \u0081", "ISO-8859-1 should be decoded as Windows-1252")
+	suite.Equal(decodeCharset([]byte("Café"), "utf-8"), "Café")
+}
+
+func (suite *EmailSuite) TestHarvestEmails() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	harvested, err := ch.HarvestEmails(context.Background(), strings.NewReader(suite.fixture("testdata/email/newsletter.eml")))
+	suite.NoError(err)
+	suite.Equal(len(harvested), 2, "The body and the text attachment should be harvested, but not the PDF")
+
+	hrs := harvested[0]
+	suite.Equal(hrs.Provenance.Kind, EmailProvenance)
+	suite.Equal(hrs.Provenance.SourceID, "digest-42@example.com")
+	suite.Equal(hrs.Provenance.Author, "Café News <news@example.com>")
+	suite.Equal(len(hrs.Resources), 1, "The mailto: link should not be harvested")
+
+	hr := hrs.Resources[0]
+	suite.Equal(hr.AnchorText(), "the story")
+	suite.Equal(hr.Provenance().EmbedStyle, HTMLAnchorEmbed)
+	isCleaned, cleanedURL := hr.IsCleaned()
+	suite.True(isCleaned)
+	suite.Equal(cleanedURL.String(), suite.server.URL+"/story")
+
+	attached := harvested[1]
+	suite.Equal(attached.Content, "“Sources” – "+suite.server.URL+"/sources costs €20\n", "The attachment should be decoded as Windows-1252")
+	suite.Equal(attached.Provenance.SourceID, "digest-42@example.com")
+	suite.Equal(len(attached.Resources), 1)
+	suite.Equal(attached.Resources[0].OriginalURLText(), suite.server.URL+"/sources")
+}
+
+func TestEmailSuite(t *testing.T) {
+	suite.Run(t, new(EmailSuite))
+}
@@ -0,0 +1,418 @@
+package harvester
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Feed is an RSS 2.0, Atom or JSON Feed document
+type Feed struct {
+	Title string
+	Link  string
+	Items []*FeedItem
+}
+
+// FeedItem is an RSS item, Atom entry or JSON Feed item. Link is the item's own page and
+// ExternalLink is the page it's about (JSON Feed's external_url); both are absolute when the
+// feed says where it's from. Content is the item's full content if it has any, otherwise its
+// description or summary; an RSS description without any markup is plain text.
+type FeedItem struct {
+	Title         string
+	GUID          string
+	Link          string
+	ExternalLink  string
+	Author        string
+	Published     time.Time
+	Content       string
+	ContentFormat ContentFormat
+	BaseURL       *url.URL
+}
+
+// rssXML is an RSS 2.0 document; the content and Dublin Core modules are used for full content and authors
+type rssXML struct {
+	Channel struct {
+		Title string       `xml:"title"`
+		Links []rssLinkXML `xml:"link"`
+		Items []rssItemXML `xml:"item"`
+	} `xml:"channel"`
+}
+
+// rssLinkXML is an RSS <link>; atom:link elements are often mixed in and have an href instead of text
+type rssLinkXML struct {
+	Value string `xml:",chardata"`
+	Href  string `xml:"href,attr"`
+}
+
+type rssItemXML struct {
+	Title          string       `xml:"title"`
+	Links          []rssLinkXML `xml:"link"`
+	GUID           string       `xml:"guid"`
+	PubDate        string       `xml:"pubDate"`
+	Author         string       `xml:"author"`
+	Creator        string       `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Description    string       `xml:"description"`
+	ContentEncoded string       `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+// atomXML is an Atom feed
+type atomXML struct {
+	Title   string         `xml:"title"`
+	Links   []atomLinkXML  `xml:"link"`
+	Base    string         `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+	Entries []atomEntryXML `xml:"entry"`
+}
+
+type atomLinkXML struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomEntryXML struct {
+	Title     atomTextXML   `xml:"title"`
+	ID        string        `xml:"id"`
+	Links     []atomLinkXML `xml:"link"`
+	Published string        `xml:"published"`
+	Updated   string        `xml:"updated"`
+	Authors   []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Content atomTextXML `xml:"content"`
+	Summary atomTextXML `xml:"summary"`
+	Base    string      `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+}
+
+// atomTextXML is an Atom text construct, whose type is text, html or xhtml
+type atomTextXML struct {
+	Type     string `xml:"type,attr"`
+	Value    string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
+// jsonFeedJSON is a JSON Feed (https://jsonfeed.org) version 1 or 1.1 document
+type jsonFeedJSON struct {
+	Title       string `json:"title"`
+	HomePageURL string `json:"home_page_url"`
+	Items       []struct {
+		ID            interface{}      `json:"id"`
+		URL           string           `json:"url"`
+		ExternalURL   string           `json:"external_url"`
+		Title         string           `json:"title"`
+		ContentHTML   string           `json:"content_html"`
+		ContentText   string           `json:"content_text"`
+		Summary       string           `json:"summary"`
+		DatePublished string           `json:"date_published"`
+		Author        *jsonFeedAuthor  `json:"author"`
+		Authors       []jsonFeedAuthor `json:"authors"`
+	} `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// feedDateFormats are the loose RFC 822 dates found in RSS feeds, in addition to the metadata date formats
+var feedDateFormats = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC822Z,
+	time.RFC822,
+}
+
+// ParseFeed reads an RSS 2.0, Atom or JSON Feed document, telling them apart by their content
+func ParseFeed(reader io.Reader) (*Feed, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		return parseJSONFeed(data)
+	}
+
+	root, err := feedRootElement(data)
+	if err != nil {
+		return nil, err
+	}
+	switch root {
+	case "rss":
+		return parseRSS(data)
+	case "feed":
+		return parseAtom(data)
+	}
+	return nil, fmt.Errorf("unsupported feed format <%s>, expected RSS 2.0 <rss>, Atom <feed> or JSON Feed", root)
+}
+
+// newFeedDecoder makes an XML decoder that also reads Latin-1 feeds
+func newFeedDecoder(data []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "iso-8859-1", "latin1", "windows-1252", "us-ascii":
+			latin1, err := ioutil.ReadAll(input)
+			if err != nil {
+				return nil, err
+			}
+			return strings.NewReader(decodeCharset(latin1, charset)), nil
+		}
+		return nil, fmt.Errorf("unsupported feed charset %q", charset)
+	}
+	return decoder
+}
+
+// feedRootElement returns the local name of the XML document's root element
+func feedRootElement(data []byte) (string, error) {
+	decoder := newFeedDecoder(data)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("unable to parse feed: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func parseRSS(data []byte) (*Feed, error) {
+	var rss rssXML
+	if err := newFeedDecoder(data).Decode(&rss); err != nil {
+		return nil, fmt.Errorf("unable to parse RSS feed: %v", err)
+	}
+
+	result := &Feed{Title: strings.TrimSpace(rss.Channel.Title), Link: rssLink(rss.Channel.Links)}
+	feedURL, _ := url.Parse(result.Link)
+	for _, item := range rss.Channel.Items {
+		feedItem := &FeedItem{
+			Title:         strings.TrimSpace(item.Title),
+			GUID:          strings.TrimSpace(item.GUID),
+			Link:          resolveMetadataURL(rssLink(item.Links), feedURL),
+			Author:        strings.TrimSpace(item.Creator),
+			Published:     parseFeedDate(item.PubDate),
+			Content:       item.ContentEncoded,
+			ContentFormat: HTMLContent,
+		}
+		if len(feedItem.Author) == 0 {
+			feedItem.Author = strings.TrimSpace(item.Author)
+		}
+		if len(strings.TrimSpace(feedItem.Content)) == 0 {
+			feedItem.Content, feedItem.ContentFormat = item.Description, rssDescriptionFormat(item.Description)
+		}
+		result.Items = append(result.Items, feedItem.withBaseURL(feedURL))
+	}
+	return result, nil
+}
+
+// rssMarkupRegEx matches the tags and character references that show an RSS description is HTML
+var rssMarkupRegEx = regexp.MustCompile(`</?[a-zA-Z][^<>]*>|&(#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`)
+
+// rssDescriptionFormat returns whether an RSS description is HTML; RSS allows either escaped HTML
+// or plain text, and plain text must be searched as such for its bare URLs to be found
+func rssDescriptionFormat(description string) ContentFormat {
+	if rssMarkupRegEx.MatchString(description) {
+		return HTMLContent
+	}
+	return PlainTextContent
+}
+
+// rssLink returns the text of the first <link> that has any, skipping atom:link elements
+func rssLink(links []rssLinkXML) string {
+	for _, link := range links {
+		if value := strings.TrimSpace(link.Value); len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+func parseAtom(data []byte) (*Feed, error) {
+	var atom atomXML
+	if err := newFeedDecoder(data).Decode(&atom); err != nil {
+		return nil, fmt.Errorf("unable to parse Atom feed: %v", err)
+	}
+
+	feedURL, _ := url.Parse(atom.Base)
+	result := &Feed{Title: strings.TrimSpace(atom.Title), Link: resolveMetadataURL(atomLink(atom.Links), feedURL)}
+	if len(result.Link) > 0 {
+		feedURL, _ = url.Parse(result.Link)
+	}
+	for _, entry := range atom.Entries {
+		entryURL := feedURL
+		if len(entry.Base) > 0 {
+			if base, err := resolveURL(entry.Base, feedURL); err == nil {
+				entryURL = base
+			}
+		}
+		feedItem := &FeedItem{
+			Title:     strings.TrimSpace(entry.Title.text()),
+			GUID:      strings.TrimSpace(entry.ID),
+			Link:      resolveMetadataURL(atomLink(entry.Links), entryURL),
+			Published: parseFeedDate(entry.Published),
+		}
+		if feedItem.Published.IsZero() {
+			feedItem.Published = parseFeedDate(entry.Updated)
+		}
+		if len(entry.Authors) > 0 {
+			feedItem.Author = strings.TrimSpace(entry.Authors[0].Name)
+		}
+		text := entry.Content
+		if len(strings.TrimSpace(text.Value)) == 0 && len(strings.TrimSpace(text.InnerXML)) == 0 {
+			text = entry.Summary
+		}
+		feedItem.Content, feedItem.ContentFormat = text.content()
+		result.Items = append(result.Items, feedItem.withBaseURL(entryURL))
+	}
+	return result, nil
+}
+
+// atomLink returns the href of the alternate link (links without a rel are alternate links)
+func atomLink(links []atomLinkXML) string {
+	for _, link := range links {
+		if len(link.Rel) == 0 || link.Rel == "alternate" {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	return ""
+}
+
+// content returns an Atom text construct's content and whether it's HTML; xhtml content is
+// kept as markup (it's a <div> wrapping the XHTML) while html content is escaped markup
+func (t atomTextXML) content() (string, ContentFormat) {
+	switch t.Type {
+	case "html":
+		return t.Value, HTMLContent
+	case "xhtml":
+		return t.InnerXML, HTMLContent
+	}
+	return t.Value, PlainTextContent
+}
+
+// text returns an Atom text construct as plain text
+func (t atomTextXML) text() string {
+	content, format := t.content()
+	if format == HTMLContent {
+		if doc, err := html.Parse(strings.NewReader(content)); err == nil {
+			return nodeText(doc)
+		}
+	}
+	return content
+}
+
+func parseJSONFeed(data []byte) (*Feed, error) {
+	var feed jsonFeedJSON
+	if err := unmarshalJSON(data, &feed); err != nil {
+		return nil, fmt.Errorf("unable to parse JSON Feed: %v", err)
+	}
+
+	result := &Feed{Title: feed.Title, Link: feed.HomePageURL}
+	feedURL, _ := url.Parse(feed.HomePageURL)
+	for _, item := range feed.Items {
+		feedItem := &FeedItem{
+			Title:         item.Title,
+			Link:          resolveMetadataURL(item.URL, feedURL),
+			ExternalLink:  resolveMetadataURL(item.ExternalURL, feedURL),
+			Published:     parseFeedDate(item.DatePublished),
+			Content:       item.ContentHTML,
+			ContentFormat: HTMLContent,
+		}
+		if item.ID != nil {
+			feedItem.GUID = fmt.Sprint(item.ID)
+		}
+		if item.Author != nil {
+			feedItem.Author = item.Author.Name
+		}
+		if len(item.Authors) > 0 {
+			feedItem.Author = item.Authors[0].Name
+		}
+		if len(feedItem.Content) == 0 {
+			feedItem.Content, feedItem.ContentFormat = item.ContentText, PlainTextContent
+		}
+		if len(feedItem.Content) == 0 {
+			feedItem.Content, feedItem.ContentFormat = item.Summary, PlainTextContent
+		}
+		result.Items = append(result.Items, feedItem.withBaseURL(feedURL))
+	}
+	return result, nil
+}
+
+// withBaseURL sets the URL that relative URLs in the item's content are resolved against,
+// which is the item's own link or else the feed's
+func (i *FeedItem) withBaseURL(feedURL *url.URL) *FeedItem {
+	i.BaseURL = feedURL
+	if link, err := url.Parse(i.Link); err == nil && link.IsAbs() {
+		i.BaseURL = link
+	}
+	if i.BaseURL != nil && !i.BaseURL.IsAbs() {
+		i.BaseURL = nil
+	}
+	return i
+}
+
+// parseFeedDate makes a best effort to parse a feed's date, returning the zero time if it can't
+func parseFeedDate(text string) time.Time {
+	text = strings.TrimSpace(text)
+	if date := parseMetadataDate(text); !date.IsZero() {
+		return date
+	}
+	for _, format := range feedDateFormats {
+		if date, err := time.Parse(format, text); err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+// ContentSource returns the item's content for harvesting with the item's links, which are
+// harvested after the URLs in its content, and with the item's provenance
+func (i *FeedItem) ContentSource() *ContentSource {
+	sourceID := i.GUID
+	if len(sourceID) == 0 {
+		sourceID = i.Link
+	}
+	provenance := &Provenance{
+		Kind:      FeedItemProvenance,
+		SourceID:  sourceID,
+		Title:     i.Title,
+		Author:    i.Author,
+		Timestamp: i.Published,
+	}
+	provenance.SourceURL, _ = url.Parse(i.Link)
+
+	result := &ContentSource{Content: i.Content, Format: i.ContentFormat, BaseURL: i.BaseURL, Provenance: provenance}
+	for _, link := range []string{i.Link, i.ExternalLink} {
+		if len(link) > 0 {
+			result.Links = append(result.Links, &DiscoveredURL{Text: link, URL: link, AnchorText: i.Title, Offset: -1, EmbedStyle: FeedItemLinkEmbed})
+		}
+	}
+	return result
+}
+
+// HarvestFeed parses an RSS 2.0, Atom or JSON Feed document (see ParseFeed) and harvests each item's
+// links and the URLs in its content, returning one HarvestedResources per item. Harvesting stops at
+// the first item that's cancelled.
+func (h *ContentHarvester) HarvestFeed(ctx context.Context, reader io.Reader) ([]*HarvestedResources, error) {
+	feed, err := ParseFeed(bufio.NewReader(reader))
+	if err != nil {
+		return nil, err
+	}
+	var result []*HarvestedResources
+	for _, item := range feed.Items {
+		harvested := h.HarvestResourcesFromSource(ctx, item.ContentSource())
+		result = append(result, harvested)
+		if isCancelled, _ := harvested.IsCancelled(); isCancelled {
+			break
+		}
+	}
+	return result, nil
+}
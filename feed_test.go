@@ -0,0 +1,151 @@
+package harvester
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type FeedSuite struct {
+	fixtureSuite
+}
+
+func (suite *FeedSuite) TestParseRSS() {
+	feed, err := ParseFeed(strings.NewReader(suite.fixture("testdata/feed/rss.xml")))
+	suite.NoError(err)
+	suite.Equal(feed.Title, "Example News")
+	suite.Equal(feed.Link, suite.server.URL+"/", "The atom:link should not be mistaken for the channel's link")
+	suite.Equal(len(feed.Items), 2)
+
+	item := feed.Items[0]
+	suite.Equal(item.Title, "First story")
+	suite.Equal(item.GUID, "story-1")
+	suite.Equal(item.Link, suite.server.URL+"/first?utm_source=rss", "Relative links should be resolved against the channel's link")
+	suite.Equal(item.Author, "Jane Writer")
+	suite.True(item.Published.Equal(time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)))
+	suite.Equal(item.ContentFormat, HTMLContent)
+	suite.Contains(item.Content, `<a href="/related">`, "content:encoded should be preferred to the description")
+
+	item = feed.Items[1]
+	suite.Equal(item.Title, "Café opens")
+	suite.Equal(item.Content, `The café is open, see <a href="`+suite.server.URL+`/menu">the menu</a>.`, "ISO-8859-1 should be converted")
+	suite.True(item.Published.Equal(time.Date(2018, 6, 2, 6, 30, 0, 0, time.UTC)))
+}
+
+func (suite *FeedSuite) TestPlainTextDescription() {
+	feed, err := ParseFeed(strings.NewReader(suite.fixture("testdata/feed/plain.xml")))
+	suite.NoError(err)
+	suite.Equal(feed.Items[0].ContentFormat, PlainTextContent, "Descriptions without markup should be plain text")
+	suite.Equal(feed.Items[1].ContentFormat, HTMLContent, "Character references show a description is HTML")
+
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	harvested, err := ch.HarvestFeed(context.Background(), strings.NewReader(suite.fixture("testdata/feed/plain.xml")))
+	suite.NoError(err)
+	hrs := harvested[0]
+	suite.Equal(len(hrs.Resources), 3, "The bare URLs in the description should be harvested as well as the item's link")
+	suite.Equal(hrs.Resources[0].OriginalURLText(), suite.server.URL+"/links/article")
+	suite.Equal(hrs.Resources[0].Provenance().EmbedStyle, PlainTextEmbed)
+	suite.Equal(hrs.Resources[1].OriginalURLText(), suite.server.URL+"/links/follow-up")
+	suite.Equal(hrs.Resources[2].Provenance().EmbedStyle, FeedItemLinkEmbed)
+}
+
+func (suite *FeedSuite) TestParseAtom() {
+	feed, err := ParseFeed(strings.NewReader(suite.fixture("testdata/feed/atom.xml")))
+	suite.NoError(err)
+	suite.Equal(feed.Title, "Example Blog")
+	suite.Equal(feed.Link, suite.server.URL+"/blog/")
+	suite.Equal(len(feed.Items), 2)
+
+	entry := feed.Items[0]
+	suite.Equal(entry.Title, "Atom entry", "HTML titles should be converted to text")
+	suite.Equal(entry.Link, suite.server.URL+"/blog/entries/1", "The alternate link should be resolved against xml:base")
+	suite.Equal(entry.Author, "Sam Blogger")
+	suite.True(entry.Published.Equal(time.Date(2018, 6, 3, 9, 0, 0, 0, time.UTC)), "The updated date should be used when there's no published date")
+	suite.Equal(entry.ContentFormat, HTMLContent)
+	suite.Equal(entry.Content, `<p>See <a href="notes">my notes</a>.</p>`)
+
+	entry = feed.Items[1]
+	suite.Equal(entry.GUID, "tag:example.com,2018:2")
+	suite.True(entry.Published.Equal(time.Date(2018, 6, 4, 8, 0, 0, 0, time.UTC)))
+	suite.Equal(entry.ContentFormat, PlainTextContent, "The summary should be used when there's no content")
+}
+
+func (suite *FeedSuite) TestParseJSONFeed() {
+	feed, err := ParseFeed(strings.NewReader(suite.fixture("testdata/feed/feed.json")))
+	suite.NoError(err)
+	suite.Equal(feed.Title, "Example Links")
+	suite.Equal(len(feed.Items), 2)
+
+	item := feed.Items[0]
+	suite.Equal(item.GUID, "1")
+	suite.Equal(item.ExternalLink, suite.server.URL+"/external")
+	suite.Equal(item.Author, "Lee Linker")
+	suite.Equal(item.ContentFormat, PlainTextContent)
+	suite.Equal(feed.Items[1].Link, suite.server.URL+"/links/2")
+	suite.Equal(feed.Items[1].ContentFormat, HTMLContent)
+
+	_, err = ParseFeed(strings.NewReader(`<?xml version="1.0"?><opml version="2.0"></opml>`))
+	suite.Error(err, "Unknown XML documents should be rejected")
+}
+
+func (suite *FeedSuite) TestHarvestFeed() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	harvested, err := ch.HarvestFeed(context.Background(), strings.NewReader(suite.fixture("testdata/feed/rss.xml")))
+	suite.NoError(err)
+	suite.Equal(len(harvested), 2, "There should be one result per item")
+
+	hrs := harvested[0]
+	suite.Equal(hrs.Provenance.Kind, FeedItemProvenance)
+	suite.Equal(hrs.Provenance.SourceID, "story-1")
+	suite.Equal(hrs.Provenance.Title, "First story")
+	suite.Equal(hrs.Provenance.Author, "Jane Writer")
+	suite.Equal(len(hrs.Resources), 2, "The item's link and the link in its content should be harvested, but not mailto:")
+
+	related := hrs.Resources[0]
+	suite.Equal(related.OriginalURLText(), suite.server.URL+"/related", "Relative URLs in content should be resolved against the item's link")
+	suite.Equal(related.Provenance().EmbedStyle, HTMLAnchorEmbed)
+
+	link := hrs.Resources[1]
+	suite.Equal(link.Provenance().EmbedStyle, FeedItemLinkEmbed, "The item's link should be harvested after its content's")
+	suite.Equal(link.AnchorText(), "First story")
+	isCleaned, cleanedURL := link.IsCleaned()
+	suite.True(isCleaned)
+	suite.Equal(cleanedURL.String(), suite.server.URL+"/first")
+
+	hrs = harvested[1]
+	suite.Equal(hrs.Provenance.SourceID, suite.server.URL+"/cafe", "The link should identify items without a GUID")
+	suite.Equal(len(hrs.Resources), 2)
+	suite.Equal(hrs.Resources[0].OriginalURLText(), suite.server.URL+"/menu")
+}
+
+func (suite *FeedSuite) TestSerializedTitle() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	harvested, err := ch.HarvestFeed(context.Background(), strings.NewReader(suite.fixture("testdata/feed/digest.xml")))
+	suite.NoError(err)
+	suite.Equal(harvested[0].Provenance.Title, "Re: Weekly: links")
+
+	tmpl, err := template.ParseFiles("serialize.md.tmpl")
+	suite.NoError(err)
+	var serialized strings.Builder
+	err = harvested[0].Serialize(HarvestedResourcesSerializer{
+		GetKeys: func(hr *HarvestedResource) *HarvestedResourceKeys {
+			return CreateHarvestedResourceKeys(hr, func(random uint32, try int) bool { return false })
+		},
+		GetTemplate: func(keys *HarvestedResourceKeys) (*template.Template, error) { return tmpl, nil },
+		GetWriter:   func(keys *HarvestedResourceKeys) io.Writer { return &serialized },
+	})
+	suite.NoError(err)
+	suite.Contains(serialized.String(), "provTitle: \"Re: Weekly: links\"\n", "Titles with colons should be quoted so the front matter is valid YAML")
+}
+
+func TestFeedSuite(t *testing.T) {
+	suite.Run(t, new(FeedSuite))
+}
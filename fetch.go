@@ -0,0 +1,49 @@
+package harvester
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultFetchTimeout is the overall time limit (including redirects and reading
+// the body) used by the default fetcher
+const DefaultFetchTimeout = 30 * time.Second
+
+// Fetcher performs all the HTTP requests the harvester makes. Because *http.Client
+// satisfies this interface, a custom client (with its own timeouts, transport, proxy,
+// etc.) or a test double may be supplied with SetFetcher.
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// MakeDefaultFetcher returns the fetcher used unless one is supplied with SetFetcher;
+// like http.Get it follows HTTP redirects but, unlike http.Get, it will not wait forever
+func MakeDefaultFetcher() Fetcher {
+	return &http.Client{Timeout: DefaultFetchTimeout}
+}
+
+// fetch issues a GET request for the given URL text through the configured fetcher;
+// the request is bound to ctx so cancellation or a deadline aborts it (and its body)
+func (h *ContentHarvester) fetch(ctx context.Context, urlText string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, urlText, nil)
+	if err != nil {
+		return nil, err
+	}
+	return h.fetcher.Do(req.WithContext(ctx))
+}
+
+// contextReader stops reading as soon as its context is done, even if the
+// underlying reader (e.g. a response body from a custom fetcher) would not
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
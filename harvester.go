@@ -1,16 +1,16 @@
 package harvester
 
 import (
+	"context"
 	"io"
 	"mime"
 	"net/http"
 	"net/url"
-	"regexp"
+	"sync"
 	"text/template"
 	"time"
 
 	"go.uber.org/zap"
-	"mvdan.cc/xurls"
 )
 
 // TODO use https://github.com/PuerkitoBio/goquery for parsing singe page HTML (similar to cheerio library for Node.js)
@@ -29,23 +29,65 @@ type CleanDiscoveredResourceRule interface {
 	RemoveQueryParamFromResource(paramName string) (bool, string)
 }
 
-// ContentHarvester discovers URLs (called "Resources" from the "R" in "URL")
+// DefaultMaxHTMLRedirects is the number of consecutive HTML (not HTTP) redirects followed
+// unless changed with SetMaxHTMLRedirects
+const DefaultMaxHTMLRedirects = 5
+
+// DefaultMaxHTMLSize is the most HTML retained from each destination (for parsing redirects,
+// page metadata, etc.) unless changed with SetMaxHTMLSize; anything beyond it is discarded
+const DefaultMaxHTMLSize = 2 * 1024 * 1024
+
+// DefaultMaxWorkers is the number of discovered URLs resolved at the same time
+// unless changed with SetMaxWorkers; the default resolves URLs sequentially.
+const DefaultMaxWorkers = 1
+
+// DefaultOccurrenceContextWidth is the number of characters (runes) of content on either side
+// of a discovered URL kept as its context, unless changed with SetOccurrenceContextWidth
+const DefaultOccurrenceContextWidth = 40
+
+// ContentHarvester discovers URLs (called "Resources" from the "R" in "URL").
+// Once configured, a ContentHarvester is safe for concurrent use.
 type ContentHarvester struct {
-	logger              *zap.Logger
-	discoverURLsRegEx   *regexp.Regexp
-	followHTMLRedirects bool
-	ignoreResourceRule  IgnoreDiscoveredResourceRule
-	cleanResourceRule   CleanDiscoveredResourceRule
-	contentEncountered  []*HarvestedResourceContent
+	logger                 *zap.Logger
+	discoverer             Discoverer
+	followHTMLRedirects    bool
+	ignoreResourceRule     IgnoreDiscoveredResourceRule
+	cleanResourceRule      CleanDiscoveredResourceRule
+	fetcher                Fetcher
+	maxHTMLRedirects       int
+	maxHTMLSize            int64
+	canonicalURLPolicy     CanonicalURLPolicy
+	cleanedURLVerification CleanedURLVerification
+	httpStatusPolicy       HTTPStatusPolicy
+	retryPolicy            RetryPolicy
+	extractArticles        bool
+	maxWorkers             int
+	occurrenceContextWidth int
+	contentMutex           sync.Mutex
+	contentEncountered     []*HarvestedResourceContent
 }
 
 // HarvestedResources is the list of URLs discovered in a piece of content
 type HarvestedResources struct {
-	Content   string
-	Resources []*HarvestedResource
+	Content      string
+	Provenance   *Provenance
+	Resources    []*HarvestedResource
+	format       ContentFormat
+	isCancelled  bool
+	cancelReason string
 }
 
-// HarvestedResourcesSerializer contains callbacks for custom serialization of resources and content
+// IsCancelled indicates whether harvesting was cancelled (or its deadline passed) before
+// all discovered URLs were harvested; if so Resources only has the completed resources.
+func (r *HarvestedResources) IsCancelled() (bool, string) {
+	return r.isCancelled, r.cancelReason
+}
+
+// HarvestedResourcesSerializer contains callbacks for custom serialization of resources and content;
+// GetTemplateParams and the Handle* callbacks are optional. Restricted and retryable destinations
+// (see HTTPStatusPolicy) go to HandleInvalidURLDest if they don't have their own callback. Templates
+// see GetTemplateParams' values as .Params, where ProvenanceType defaults to the resource's Provenance
+// Kind, alongside the full .Provenance.
 type HarvestedResourcesSerializer struct {
 	GetKeys              func(*HarvestedResource) *HarvestedResourceKeys
 	GetTemplate          func(*HarvestedResourceKeys) (*template.Template, error)
@@ -53,6 +95,8 @@ type HarvestedResourcesSerializer struct {
 	GetWriter            func(*HarvestedResourceKeys) io.Writer
 	HandleInvalidURL     func(*HarvestedResource)
 	HandleInvalidURLDest func(*HarvestedResource)
+	HandleRestrictedURL  func(*HarvestedResource)
+	HandleRetryableURL   func(*HarvestedResource)
 	HandleIgnoredURL     func(*HarvestedResource)
 }
 
@@ -67,7 +111,14 @@ func (r *HarvestedResources) Serialize(serializer HarvestedResourcesSerializer)
 			continue
 		}
 		if !isDestValid {
-			if serializer.HandleInvalidURLDest != nil {
+			isRestricted, _ := hr.IsRestricted()
+			isRetryable, _ := hr.IsRetryable()
+			switch {
+			case isRestricted && serializer.HandleRestrictedURL != nil:
+				serializer.HandleRestrictedURL(hr)
+			case isRetryable && serializer.HandleRetryableURL != nil:
+				serializer.HandleRetryableURL(hr)
+			case serializer.HandleInvalidURLDest != nil:
 				serializer.HandleInvalidURLDest(hr)
 			}
 			continue
@@ -86,28 +137,67 @@ func (r *HarvestedResources) Serialize(serializer HarvestedResourcesSerializer)
 		if tmplErr != nil {
 			return tmplErr
 		}
-		params := serializer.GetTemplateParams(keys)
+		// templates written before resources had a Provenance read its kind from .Params.ProvenanceType
+		params := make(map[string]interface{})
+		if provenance := hr.Provenance(); provenance != nil {
+			params["ProvenanceType"] = string(provenance.Kind)
+		}
+		if serializer.GetTemplateParams != nil {
+			if custom := serializer.GetTemplateParams(keys); custom != nil {
+				for name, value := range *custom {
+					params[name] = value
+				}
+			}
+		}
 		writer := serializer.GetWriter(keys)
 
 		isCleaned, _ := hr.IsCleaned()
 		finalURL, resolvedURL, _ := hr.GetURLs()
+		var metadata *PageMetadata
+		var article *Article
+		var markdown string
+		var markdownErr error
+		if content := hr.ResourceContent(); content != nil {
+			metadata = content.Metadata
+			article = content.Article
+		}
+		if article != nil && article.Error == nil {
+			// a resource whose article can't be converted is still serialized, with the error
+			markdown, markdownErr = ConvertHTMLToMarkdown(article.HTML, finalURL)
+		}
 		err := t.Execute(writer, struct {
-			Content     string
-			Resource    *HarvestedResource
-			HarvestedOn time.Time
-			IsCleaned   bool
-			FinalURL    string
-			ResolvedURL string
-			Params      *map[string]interface{}
-			Slug        string
+			Content       string
+			Harvested     *HarvestedResources
+			Resource      *HarvestedResource
+			Provenance    *Provenance
+			HarvestedOn   time.Time
+			IsCleaned     bool
+			FinalURL      string
+			ResolvedURL   string
+			CanonicalURL  *url.URL
+			RedirectChain []*RedirectHop
+			Metadata      *PageMetadata
+			Article       *Article
+			Markdown      string
+			MarkdownError error
+			Params        *map[string]interface{}
+			Slug          string
 		}{
 			r.Content,
+			r,
 			hr,
+			hr.Provenance(),
 			hr.harvestedDate,
 			isCleaned,
 			finalURL.String(),
 			resolvedURL.String(),
-			params,
+			hr.canonicalURL,
+			hr.RedirectChain(),
+			metadata,
+			article,
+			markdown,
+			markdownErr,
+			&params,
 			keys.Slug(),
 		})
 		if err != nil {
@@ -118,26 +208,130 @@ func (r *HarvestedResources) Serialize(serializer HarvestedResourcesSerializer)
 	return nil
 }
 
-// MakeContentHarvester prepares a content harvester
+// MakeContentHarvester prepares a content harvester; its HTTP requests are made by
+// MakeDefaultFetcher() unless SetFetcher is called
 func MakeContentHarvester(logger *zap.Logger, ignoreResourceRule IgnoreDiscoveredResourceRule, cleanResourceRule CleanDiscoveredResourceRule, followHTMLRedirects bool) *ContentHarvester {
 	result := new(ContentHarvester)
 	result.logger = logger
-	result.discoverURLsRegEx = xurls.Relaxed
+	result.discoverer = MakeURLDiscoverer(URLDiscoveryConfig{})
 	result.ignoreResourceRule = ignoreResourceRule
 	result.cleanResourceRule = cleanResourceRule
 	result.followHTMLRedirects = followHTMLRedirects
+	result.fetcher = MakeDefaultFetcher()
+	result.maxHTMLRedirects = DefaultMaxHTMLRedirects
+	result.maxHTMLSize = DefaultMaxHTMLSize
+	result.maxWorkers = DefaultMaxWorkers
+	result.httpStatusPolicy = DefaultHTTPStatusPolicy()
+	result.occurrenceContextWidth = DefaultOccurrenceContextWidth
 	return result
 }
 
+// SetFetcher replaces the fetcher that makes all of the harvester's HTTP requests, e.g. with an
+// *http.Client that has its own timeouts, transport or proxy; nil restores MakeDefaultFetcher().
+// This should be called before harvesting begins.
+func (h *ContentHarvester) SetFetcher(fetcher Fetcher) {
+	if fetcher == nil {
+		fetcher = MakeDefaultFetcher()
+	}
+	h.fetcher = fetcher
+}
+
+// SetMaxHTMLRedirects sets how many consecutive HTML (not HTTP) redirects are followed
+// when followHTMLRedirects is true. This should be called before harvesting begins.
+func (h *ContentHarvester) SetMaxHTMLRedirects(maxHTMLRedirects int) {
+	if maxHTMLRedirects < 0 {
+		maxHTMLRedirects = 0
+	}
+	h.maxHTMLRedirects = maxHTMLRedirects
+}
+
+// SetMaxHTMLSize sets the most HTML, in bytes, retained from each destination. This should be
+// called before harvesting begins.
+func (h *ContentHarvester) SetMaxHTMLSize(maxHTMLSize int64) {
+	if maxHTMLSize < 0 {
+		maxHTMLSize = 0
+	}
+	h.maxHTMLSize = maxHTMLSize
+}
+
+// SetCanonicalURLPolicy sets whether the canonical URL declared by HTML destinations (via rel="canonical"
+// or og:url) is ignored, recorded, or used as the finalURL. This should be called before harvesting begins.
+func (h *ContentHarvester) SetCanonicalURLPolicy(policy CanonicalURLPolicy) {
+	h.canonicalURLPolicy = policy
+}
+
+// SetCleanedURLVerification sets how cleaned URLs are checked before being used as the finalURL, since
+// removing query parameters might break a URL. This should be called before harvesting begins.
+func (h *ContentHarvester) SetCleanedURLVerification(verification CleanedURLVerification) {
+	h.cleanedURLVerification = verification
+}
+
+// SetHTTPStatusPolicy sets which HTTP status codes make a destination valid, invalid, retryable or
+// restricted; if policy is nil DefaultHTTPStatusPolicy() is used. This should be called before harvesting begins.
+func (h *ContentHarvester) SetHTTPStatusPolicy(policy HTTPStatusPolicy) {
+	if policy == nil {
+		policy = DefaultHTTPStatusPolicy()
+	}
+	h.httpStatusPolicy = policy
+}
+
+// SetRetryPolicy sets how connection errors, timeouts and retryable HTTP status codes are retried;
+// by default they aren't. This should be called before harvesting begins.
+func (h *ContentHarvester) SetRetryPolicy(policy RetryPolicy) {
+	if policy.MaxRetries < 0 {
+		policy.MaxRetries = 0
+	}
+	h.retryPolicy = policy
+}
+
+// SetArticleExtraction sets whether the readable article (see ExtractArticle) is extracted from
+// HTML destinations, which is off by default. This should be called before harvesting begins.
+func (h *ContentHarvester) SetArticleExtraction(extractArticles bool) {
+	h.extractArticles = extractArticles
+}
+
+// SetURLDiscovery sets which URLs are discovered in content, replacing any custom Discoverer;
+// the default is relaxed discovery with no extra validation. This should be called before harvesting begins.
+func (h *ContentHarvester) SetURLDiscovery(config URLDiscoveryConfig) {
+	h.discoverer = MakeURLDiscoverer(config)
+}
+
+// SetDiscoverer replaces the built-in URL discovery with a custom Discoverer. This should be
+// called before harvesting begins.
+func (h *ContentHarvester) SetDiscoverer(discoverer Discoverer) {
+	h.discoverer = discoverer
+}
+
+// SetMaxWorkers sets how many discovered URLs are resolved concurrently; the order of
+// harvested resources always matches the order URLs were discovered in the content.
+// This should be called before harvesting begins.
+func (h *ContentHarvester) SetMaxWorkers(maxWorkers int) {
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+	h.maxWorkers = maxWorkers
+}
+
+// SetOccurrenceContextWidth sets how many characters (runes) of content on either side of each
+// discovered URL are kept as its context; 0 keeps none. This should be called before harvesting begins.
+func (h *ContentHarvester) SetOccurrenceContextWidth(width int) {
+	if width < 0 {
+		width = 0
+	}
+	h.occurrenceContextWidth = width
+}
+
 // Close will clean up resources, mainly temporary files that were created for downloaded resources
 func (h *ContentHarvester) Close() {
 
 }
 
 // detectContentType will figure out what kind of destination content we're dealing with
-func (h *ContentHarvester) detectResourceContent(url *url.URL, resp *http.Response) *HarvestedResourceContent {
+func (h *ContentHarvester) detectResourceContent(ctx context.Context, url *url.URL, resp *http.Response) *HarvestedResourceContent {
 	result := new(HarvestedResourceContent)
+	h.contentMutex.Lock()
 	h.contentEncountered = append(h.contentEncountered, result)
+	h.contentMutex.Unlock()
 	result.URL = url
 	result.ContentType = resp.Header.Get("Content-Type")
 	if len(result.ContentType) > 0 {
@@ -146,39 +340,116 @@ func (h *ContentHarvester) detectResourceContent(url *url.URL, resp *http.Respon
 			return result
 		}
 		if result.IsHTML() {
+			// HTML is kept in memory (up to a limit) rather than downloaded so it can be parsed
+			result.HTML, result.HTMLTruncated, result.HTMLReadError = readHTML(ctx, resp.Body, h.maxHTMLSize)
 			return result
 		}
 	}
 
 	// If we get to here it means that we need to download the content to inspect it.
 	// We download it first because it's possible we want to retain it for later use.
-	result.Downloaded = DownloadContent(url, resp)
+	result.Downloaded = DownloadContentContext(ctx, url, resp)
 	return result
 }
 
 // HarvestResources discovers URLs within content and returns what was found
 func (h *ContentHarvester) HarvestResources(content string) *HarvestedResources {
+	return h.HarvestResourcesContext(context.Background(), content)
+}
+
+// HarvestResourcesContext discovers URLs within content and returns what was found. If ctx
+// is cancelled or its deadline passes, harvesting stops and the resources completed so far
+// are returned with IsCancelled() reporting the reason.
+func (h *ContentHarvester) HarvestResourcesContext(ctx context.Context, content string) *HarvestedResources {
+	return h.HarvestResourcesFromSource(ctx, &ContentSource{Content: content, Format: PlainTextContent})
+}
+
+// HarvestResourcesFromSource discovers URLs within HTML, Markdown or plain text content (see
+// ContentFormat) and returns what was found. Cancellation works like HarvestResourcesContext.
+func (h *ContentHarvester) HarvestResourcesFromSource(ctx context.Context, source *ContentSource) *HarvestedResources {
 	result := new(HarvestedResources)
-	result.Content = content
+	result.Content = source.Content
+	result.format = source.Format
+	result.Provenance = resourceProvenance(source.Provenance, nil)
 
-	seenUrls := make(map[string]bool)
-	urls := h.discoverURLsRegEx.FindAllString(content, -1)
-	for _, urlText := range urls {
-		_, found := seenUrls[urlText]
+	// every occurrence is kept but each URL is only harvested once
+	seenUrls := make(map[string]int)
+	var urls [][]*DiscoveredURL
+	var discoveredURLs []*DiscoveredURL
+	if !source.LinksOnly {
+		discoveredURLs = h.discoverer.DiscoverURLs(source)
+	}
+	for _, link := range source.Links {
+		// the source's links are copied since their occurrence details are filled in below
+		copied := *link
+		discoveredURLs = append(discoveredURLs, &copied)
+	}
+	for _, discovered := range discoveredURLs {
+		locateDiscoveredURL(source.Content, discovered, h.occurrenceContextWidth)
+		index, found := seenUrls[discovered.URL]
 		if found {
+			urls[index] = append(urls[index], discovered)
 			continue
 		}
+		seenUrls[discovered.URL] = len(urls)
+		urls = append(urls, []*DiscoveredURL{discovered})
+	}
+
+	// each worker fills in its own slot so the original ordering is preserved
+	harvested := make([]*HarvestedResource, len(urls))
+	workers := h.maxWorkers
+	if workers > len(urls) {
+		workers = len(urls)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				harvested[index] = h.harvestDiscoveredResource(ctx, urls[index], source.Provenance)
+			}
+		}()
+	}
 
-		res := harvestResource(h, urlText)
-		// check and see if we have an HTML content-based redirect via meta refresh (not HTTP)
-		referredTo := harvestResourceFromReferrer(h, res)
-		if referredTo != nil && h.followHTMLRedirects {
-			// if we had a redirect, then that's the one we'll use
-			res = referredTo
+queue:
+	for index := range urls {
+		select {
+		case jobs <- index:
+		case <-ctx.Done():
+			break queue
 		}
+	}
+	close(jobs)
+	wg.Wait()
 
-		result.Resources = append(result.Resources, res)
-		seenUrls[urlText] = true
+	for _, res := range harvested {
+		if res != nil {
+			result.Resources = append(result.Resources, res)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		result.isCancelled = true
+		result.cancelReason = err.Error()
 	}
 	return result
 }
+
+// harvestDiscoveredResource resolves a single discovered URL given all its occurrences, returning
+// nil if it was interrupted by cancellation since an incomplete resource would look invalid
+func (h *ContentHarvester) harvestDiscoveredResource(ctx context.Context, occurrences []*DiscoveredURL, provenance *Provenance) *HarvestedResource {
+	res := harvestResource(ctx, h, occurrences[0].URL)
+	res.occurrences = occurrences
+	res.provenance = resourceProvenance(provenance, occurrences[0])
+	// check and see if we have HTML content-based redirects via meta refresh (not HTTP);
+	// if we do, then the last one in the chain is the one we'll use
+	if h.followHTMLRedirects {
+		res = harvestHTMLRedirects(ctx, h, res)
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return res
+}
@@ -39,8 +39,11 @@ func (keys *HarvestedResourceKeys) IsValid() bool {
 	return keys.piError == nil
 }
 
-// Slug returns the title of the content
+// Slug returns the title of the content, preferring the merged page metadata's title
 func (keys *HarvestedResourceKeys) Slug() string {
+	if content := keys.hr.ResourceContent(); content != nil && content.Metadata != nil && len(content.Metadata.Title) > 0 {
+		return slugify.Slugify(content.Metadata.Title)
+	}
 	if keys.piError == nil {
 		return slugify.Slugify(keys.pageInfo.Title)
 	}
@@ -70,17 +73,22 @@ func generateUniqueID(existsFn KeyExists) uint32 {
 	return nextRandomNumber()
 }
 
-// CreateHarvestedResourceKeys returns a new resource keys object
+// CreateHarvestedResourceKeys returns a new resource keys object; page information is parsed
+// from the HTML retained when the resource was harvested so no network access is required
 func CreateHarvestedResourceKeys(hr *HarvestedResource, existsFn KeyExists) *HarvestedResourceKeys {
 	result := new(HarvestedResourceKeys)
 	result.hr = hr
 	result.uniqueID = generateUniqueID(existsFn)
-	// TODO this does an extra HTTP get, instead we should re-use a downloaded HTML
-	if hr.finalURL != nil {
-		result.pageInfo, result.piError = og.GetPageInfoFromUrl(hr.finalURL.String())
-	} else {
+	content := hr.ResourceContent()
+	if hr.finalURL == nil {
 		result.pageInfo = nil
 		result.piError = fmt.Errorf("HR %s finalURL is null", hr.OriginalURLText())
+	} else if content == nil || !content.IsHTML() || len(content.HTML) == 0 {
+		result.pageInfo = nil
+		result.piError = fmt.Errorf("HR %s has no HTML content", hr.OriginalURLText())
+	} else {
+		result.pageInfo = new(og.PageInfo)
+		result.piError = og.GetPageDataFromHtml(content.HTML, result.pageInfo)
 	}
 
 	return result
@@ -0,0 +1,379 @@
+package harvester
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// markdownEscaper backslash-escapes the punctuation that has meaning anywhere inside CommonMark text
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`, `>`, `\>`)
+
+// markdownLineStartRegEx matches text that would become a heading, list item, quote, etc. at the start of a line
+var markdownLineStartRegEx = regexp.MustCompile(`^(#|=|-|\+|\d+[.)](\s|$))`)
+
+// markdownListItemRegEx matches the first line of a rendered list item
+var markdownListItemRegEx = regexp.MustCompile(`^(-|\d+\.) `)
+
+// whitespaceRegEx matches runs of whitespace, which HTML renders as a single space
+var whitespaceRegEx = regexp.MustCompile(`\s+`)
+
+// ConvertHTMLToMarkdown converts HTML (typically an extracted Article) to CommonMark. Headings, paragraphs,
+// lists, links, images, emphasis, code, blockquotes and horizontal rules are converted; tables become
+// GitHub Flavored Markdown pipe tables since CommonMark has no table syntax. Relative link and image
+// URLs are resolved against baseURL (if it's not nil).
+func ConvertHTMLToMarkdown(htmlText string, baseURL *url.URL) (string, error) {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(htmlText), context)
+	if err != nil {
+		return "", err
+	}
+
+	c := &markdownConverter{baseURL}
+	markdown := strings.Join(c.blocks(nodes), "\n\n")
+	if len(markdown) > 0 {
+		markdown += "\n"
+	}
+	return markdown, nil
+}
+
+// TemplateFuncs returns the functions available to templates used with HarvestedResources.Serialize;
+// they must be added (using Funcs) before the template is parsed. The functions are:
+//
+//	markdown HTML [baseURL]: converts HTML to Markdown using ConvertHTMLToMarkdown
+//	rewrite HARVESTED FORM [stripIgnored]: rewrites .Harvested content using RewriteContent, where
+//	  FORM is "plain", "markdown" or "html"
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"rewrite": func(harvested *HarvestedResources, formName string, stripIgnored ...bool) (string, error) {
+			form, ok := rewriteLinkForms[formName]
+			if !ok {
+				return "", fmt.Errorf("unknown rewrite form %q, expected plain, markdown or html", formName)
+			}
+			return harvested.RewriteContent(RewritePolicy{Form: form, StripIgnored: len(stripIgnored) > 0 && stripIgnored[0]}), nil
+		},
+		"markdown": func(htmlText string, baseURLText ...string) (string, error) {
+			var baseURL *url.URL
+			if len(baseURLText) > 0 && len(baseURLText[0]) > 0 {
+				var err error
+				if baseURL, err = url.Parse(baseURLText[0]); err != nil {
+					return "", err
+				}
+			}
+			return ConvertHTMLToMarkdown(htmlText, baseURL)
+		},
+	}
+}
+
+// markdownConverter renders parsed HTML nodes as Markdown
+type markdownConverter struct {
+	baseURL *url.URL
+}
+
+// blocks renders a list of sibling nodes as Markdown blocks; runs of inline nodes become paragraphs
+func (c *markdownConverter) blocks(nodes []*html.Node) []string {
+	var result []string
+	var paragraph strings.Builder
+	flush := func() {
+		if text := c.paragraph(paragraph.String()); len(text) > 0 {
+			result = append(result, text)
+		}
+		paragraph.Reset()
+	}
+
+	for _, n := range nodes {
+		if isMarkdownBlock(n) {
+			flush()
+			if block := c.block(n); len(block) > 0 {
+				result = append(result, block)
+			}
+		} else {
+			paragraph.WriteString(c.inline(n))
+		}
+	}
+	flush()
+	return result
+}
+
+// childBlocks renders the children of a node as Markdown blocks
+func (c *markdownConverter) childBlocks(n *html.Node) []string {
+	var children []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, child)
+	}
+	return c.blocks(children)
+}
+
+// paragraph tidies up rendered inline content so that it's a valid paragraph
+func (c *markdownConverter) paragraph(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if markdownLineStartRegEx.MatchString(line) {
+			line = `\` + line
+		}
+		lines[i] = line
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// block renders a block-level element
+func (c *markdownConverter) block(n *html.Node) string {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level, _ := strconv.Atoi(n.Data[1:])
+		text := strings.Replace(c.paragraph(c.childInline(n)), "\\\n", " ", -1)
+		if len(text) == 0 {
+			return ""
+		}
+		return strings.Repeat("#", level) + " " + text
+	case atom.Ul, atom.Ol:
+		return c.list(n)
+	case atom.Blockquote:
+		return prefixLines(strings.Join(c.childBlocks(n), "\n\n"), "> ", ">")
+	case atom.Pre:
+		return c.codeBlock(n)
+	case atom.Hr:
+		return "---"
+	case atom.Table:
+		return c.table(n)
+	case atom.Script, atom.Style, atom.Noscript, atom.Template:
+		return ""
+	}
+	return strings.Join(c.childBlocks(n), "\n\n")
+}
+
+// list renders an ordered or unordered list, indenting nested content under each item's marker
+func (c *markdownConverter) list(n *html.Node) string {
+	number := 1
+	if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		number = start
+	}
+
+	var items []string
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+
+		var content strings.Builder
+		for i, block := range c.childBlocks(li) {
+			if i > 0 {
+				// nested lists stay tight, anything else needs a blank line to stay in the item
+				if markdownListItemRegEx.MatchString(block) {
+					content.WriteString("\n")
+				} else {
+					content.WriteString("\n\n")
+				}
+			}
+			content.WriteString(block)
+		}
+		indent := strings.Repeat(" ", len(marker))
+		items = append(items, marker+strings.TrimPrefix(prefixLines(content.String(), indent, ""), indent))
+	}
+	return strings.Join(items, "\n")
+}
+
+// codeBlock renders preformatted text as a fenced code block, keeping the language if it's declared
+// with a language-* or lang-* class (the convention used by most syntax highlighters)
+func (c *markdownConverter) codeBlock(n *html.Node) string {
+	var language string
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.DataAtom == atom.Code {
+			for _, class := range strings.Fields(getAttr(child, "class")) {
+				if strings.HasPrefix(class, "language-") || strings.HasPrefix(class, "lang-") {
+					language = class[strings.Index(class, "-")+1:]
+				}
+			}
+		}
+	}
+
+	code := strings.TrimRight(nodeText(n), "\n")
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + language + "\n" + code + "\n" + fence
+}
+
+// table renders a table as a pipe table with the first row as its header
+func (c *markdownConverter) table(n *html.Node) string {
+	var rows [][]string
+	var findRows func(*html.Node)
+	findRows = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode || child.DataAtom == atom.Table {
+				continue
+			}
+			if child.DataAtom != atom.Tr {
+				findRows(child)
+				continue
+			}
+			var row []string
+			for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+					text := strings.Replace(c.paragraph(c.childInline(cell)), "\\\n", " ", -1)
+					row = append(row, strings.Replace(strings.Replace(text, "|", `\|`, -1), "\n", " ", -1))
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	findRows(n)
+
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	var lines []string
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// childInline renders the children of a node as inline Markdown
+func (c *markdownConverter) childInline(n *html.Node) string {
+	var result strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		result.WriteString(c.inline(child))
+	}
+	return result.String()
+}
+
+// inline renders text and inline elements
+func (c *markdownConverter) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return markdownEscaper.Replace(whitespaceRegEx.ReplaceAllString(n.Data, " "))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		return "\\\n"
+	case atom.Em, atom.I, atom.Cite:
+		return wrapInline(c.childInline(n), "*")
+	case atom.Strong, atom.B:
+		return wrapInline(c.childInline(n), "**")
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		return codeSpan(nodeText(n))
+	case atom.A:
+		text := strings.TrimSpace(c.childInline(n))
+		href := c.resolve(getAttr(n, "href"))
+		if len(href) == 0 || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			return text
+		}
+		if len(text) == 0 {
+			return "<" + href + ">"
+		}
+		return "[" + text + "](" + href + markdownTitle(getAttr(n, "title")) + ")"
+	case atom.Img:
+		src := c.resolve(getAttr(n, "src"))
+		if len(src) == 0 {
+			return ""
+		}
+		alt := markdownEscaper.Replace(whitespaceRegEx.ReplaceAllString(strings.TrimSpace(getAttr(n, "alt")), " "))
+		return "![" + alt + "](" + src + markdownTitle(getAttr(n, "title")) + ")"
+	case atom.Script, atom.Style, atom.Noscript, atom.Template:
+		return ""
+	}
+	return c.childInline(n)
+}
+
+// resolve returns an absolute URL suitable for a Markdown link destination
+func (c *markdownConverter) resolve(urlText string) string {
+	urlText = strings.TrimSpace(urlText)
+	if len(urlText) == 0 {
+		return ""
+	}
+	resolved, err := resolveURL(urlText, c.baseURL)
+	if err != nil {
+		return strings.Replace(strings.Replace(urlText, "(", "%28", -1), ")", "%29", -1)
+	}
+	urlText = resolved.String()
+	return strings.Replace(strings.Replace(strings.Replace(urlText, " ", "%20", -1), "(", "%28", -1), ")", "%29", -1)
+}
+
+// isMarkdownBlock returns true if the node should be rendered as its own Markdown block
+func isMarkdownBlock(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	tag := strings.ToLower(n.Data)
+	return tag != "br" && (blockElements[tag] || tag == "main" || tag == "nav" || tag == "tbody")
+}
+
+// wrapInline surrounds text with emphasis markers, keeping any surrounding spaces outside them
+func wrapInline(text string, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if len(trimmed) == 0 {
+		return text
+	}
+	leading := text[:strings.Index(text, trimmed)]
+	trailing := text[len(leading)+len(trimmed):]
+	return leading + marker + trimmed + marker + trailing
+}
+
+// codeSpan renders code using enough backticks that backticks inside it don't end the span
+func codeSpan(code string) string {
+	code = whitespaceRegEx.ReplaceAllString(code, " ")
+	if len(strings.TrimSpace(code)) == 0 {
+		return ""
+	}
+	fence := "`"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
+
+// markdownTitle renders an optional link or image title
+func markdownTitle(title string) string {
+	title = strings.TrimSpace(title)
+	if len(title) == 0 {
+		return ""
+	}
+	return ` "` + strings.Replace(title, `"`, `\"`, -1) + `"`
+}
+
+// prefixLines adds a prefix to every line, using emptyPrefix for blank lines
+func prefixLines(text string, prefix string, emptyPrefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if len(line) == 0 {
+			lines[i] = emptyPrefix
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
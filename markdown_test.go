@@ -0,0 +1,71 @@
+package harvester
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MarkdownSuite struct {
+	suite.Suite
+	baseURL *url.URL
+}
+
+func (suite *MarkdownSuite) SetupSuite() {
+	suite.baseURL, _ = url.Parse("https://example.com/blog/post.html")
+}
+
+func (suite *MarkdownSuite) convert(htmlText string) string {
+	markdown, err := ConvertHTMLToMarkdown(htmlText, suite.baseURL)
+	suite.NoError(err, "HTML should have been converted")
+	return markdown
+}
+
+func (suite *MarkdownSuite) TestBlocks() {
+	suite.Equal("# Title\n\n## Section *one*\n\nFirst paragraph with **bold** text.\n\n---\n",
+		suite.convert("<div><h1>Title</h1><h2>Section <em>one</em></h2><p>First   paragraph\n with <b>bold</b> text.</p><hr></div>"))
+	suite.Equal("> Quoted text\n>\n> Second quoted paragraph\n",
+		suite.convert("<blockquote><p>Quoted text</p><p>Second quoted paragraph</p></blockquote>"))
+	suite.Equal("````go\nfunc main() {\n\tfmt.Println(\"```\")\n}\n````\n",
+		suite.convert("<pre><code class=\"language-go\">func main() {\n\tfmt.Println(\"```\")\n}\n</code></pre>"))
+}
+
+func (suite *MarkdownSuite) TestLists() {
+	suite.Equal("- One\n- Two\n  - Nested\n- Three\n",
+		suite.convert("<ul><li>One</li><li>Two<ul><li>Nested</li></ul></li><li>Three</li></ul>"))
+	suite.Equal("3. Third\n4. Fourth\n",
+		suite.convert("<ol start=\"3\"><li>Third</li><li>Fourth</li></ol>"))
+}
+
+func (suite *MarkdownSuite) TestLinksAndImages() {
+	suite.Equal("See [the docs](https://example.com/docs/intro \"Read me\") and ![a chart](https://example.com/blog/chart.png).\n",
+		suite.convert("<p>See <a href=\"/docs/intro\" title=\"Read me\">the docs</a> and <img src=\"chart.png\" alt=\"a chart\">.</p>"))
+	suite.Equal("Call `x := a*b` then <https://example.com/blog/next>\n",
+		suite.convert("<p>Call <code>x := a*b</code> then <a href=\"next\"></a></p>"))
+}
+
+func (suite *MarkdownSuite) TestEscaping() {
+	suite.Equal("\\1. not a list, 2 \\* 3 \\_and\\_ \\[brackets\\]\n",
+		suite.convert("<p>1. not a list, 2 * 3 _and_ [brackets]</p>"))
+}
+
+func (suite *MarkdownSuite) TestTables() {
+	suite.Equal("| Name | Value |\n| --- | --- |\n| a \\| b | 1 |\n| c |  |\n",
+		suite.convert("<table><thead><tr><th>Name</th><th>Value</th></tr></thead><tbody><tr><td>a | b</td><td>1</td></tr><tr><td>c</td></tr></tbody></table>"))
+}
+
+func (suite *MarkdownSuite) TestTemplateFunc() {
+	tmpl, err := template.New("test").Funcs(TemplateFuncs()).Parse(`{{ markdown .HTML .URL }}`)
+	suite.NoError(err, "Template should parse")
+	var out strings.Builder
+	err = tmpl.Execute(&out, map[string]string{"HTML": "<p><a href=\"/about\">About</a></p>", "URL": "https://example.com/"})
+	suite.NoError(err, "Template should execute")
+	suite.Equal("[About](https://example.com/about)\n", out.String())
+}
+
+func TestMarkdownSuite(t *testing.T) {
+	suite.Run(t, new(MarkdownSuite))
+}
@@ -0,0 +1,344 @@
+package harvester
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Sources of page metadata, in order of precedence
+const (
+	MetadataOpenGraph    = "OpenGraph"
+	MetadataJSONLD       = "JSON-LD"
+	MetadataTwitterCard  = "Twitter Card"
+	MetadataHTMLFallback = "HTML"
+)
+
+// jsonLDTypes are the schema.org types whose JSON-LD properties are used for page metadata
+var jsonLDTypes = map[string]bool{
+	"Article":              true,
+	"NewsArticle":          true,
+	"BlogPosting":          true,
+	"ReportageNewsArticle": true,
+	"VideoObject":          true,
+}
+
+// PageMetadata is what an HTML page says about itself. Each field is merged from several sources
+// and the first source that has a value wins, in this order of precedence:
+//
+//  1. OpenGraph <meta property="og:*"> (and article:* for author and dates)
+//  2. schema.org JSON-LD for Article, NewsArticle, BlogPosting or VideoObject
+//  3. Twitter Cards <meta name="twitter:*">
+//  4. HTML fallbacks: <title>, <meta name="description">, <meta name="author">,
+//     <meta name="date">, <html lang> and the first <h1>
+//
+// Sources records which of these supplied each field (e.g. Sources["Title"] == MetadataOpenGraph).
+type PageMetadata struct {
+	Title         string
+	Description   string
+	URL           string
+	SiteName      string
+	Type          string
+	Image         string
+	Video         string
+	Author        string
+	PublishedText string
+	PublishedDate time.Time
+	ModifiedText  string
+	ModifiedDate  time.Time
+	Locale        string
+	TwitterCard   string
+	Heading       string
+	Sources       map[string]string
+}
+
+// pageMetadataSource collects the raw values found in one source of metadata
+type pageMetadataSource map[string]string
+
+// ParsePageMetadata extracts and merges the metadata in a parsed HTML document; relative
+// URLs (e.g. images) are resolved against pageURL, or the document's <base href> if it has one
+func ParsePageMetadata(doc *html.Node, pageURL *url.URL) *PageMetadata {
+	openGraph := make(pageMetadataSource)
+	twitter := make(pageMetadataSource)
+	fallback := make(pageMetadataSource)
+	var jsonLD pageMetadataSource
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch strings.ToLower(n.Data) {
+			case "html":
+				fallback.set("Locale", getAttr(n, "lang"))
+			case "title":
+				if n.FirstChild != nil {
+					fallback.set("Title", n.FirstChild.Data)
+				}
+			case "h1":
+				fallback.set("Heading", nodeText(n))
+			case "meta":
+				collectMetaTag(n, openGraph, twitter, fallback)
+			case "script":
+				if jsonLD == nil && strings.EqualFold(strings.TrimSpace(getAttr(n, "type")), "application/ld+json") && n.FirstChild != nil {
+					jsonLD = parseJSONLD(n.FirstChild.Data)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	fallback.set("Title", fallback["Heading"])
+
+	result := new(PageMetadata)
+	result.Sources = make(map[string]string)
+	sources := []struct {
+		name   string
+		values pageMetadataSource
+	}{
+		{MetadataOpenGraph, openGraph},
+		{MetadataJSONLD, jsonLD},
+		{MetadataTwitterCard, twitter},
+		{MetadataHTMLFallback, fallback},
+	}
+	merge := func(field string, dest *string) {
+		for _, source := range sources {
+			if value := source.values[field]; len(value) > 0 {
+				*dest = value
+				result.Sources[field] = source.name
+				return
+			}
+		}
+	}
+	merge("Title", &result.Title)
+	merge("Description", &result.Description)
+	merge("URL", &result.URL)
+	merge("SiteName", &result.SiteName)
+	merge("Type", &result.Type)
+	merge("Image", &result.Image)
+	merge("Video", &result.Video)
+	merge("Author", &result.Author)
+	merge("Published", &result.PublishedText)
+	merge("Modified", &result.ModifiedText)
+	merge("Locale", &result.Locale)
+	merge("TwitterCard", &result.TwitterCard)
+	result.Heading = fallback["Heading"]
+
+	baseURL := findBaseURL(doc, pageURL)
+	result.URL = resolveMetadataURL(result.URL, baseURL)
+	result.Image = resolveMetadataURL(result.Image, baseURL)
+	result.Video = resolveMetadataURL(result.Video, baseURL)
+	result.PublishedDate = parseMetadataDate(result.PublishedText)
+	result.ModifiedDate = parseMetadataDate(result.ModifiedText)
+	return result
+}
+
+// set records the first non-empty value for a field
+func (s pageMetadataSource) set(field string, value string) {
+	value = strings.Join(strings.Fields(value), " ")
+	if _, found := s[field]; !found && len(value) > 0 {
+		s[field] = value
+	}
+}
+
+// collectMetaTag sorts a <meta> tag's value into the source it belongs to
+func collectMetaTag(n *html.Node, openGraph, twitter, fallback pageMetadataSource) {
+	content := getAttr(n, "content")
+	property := strings.ToLower(strings.TrimSpace(getAttr(n, "property")))
+	name := strings.ToLower(strings.TrimSpace(getAttr(n, "name")))
+	if len(property) == 0 {
+		// some sites use name= for OpenGraph properties, and property= for Twitter Cards
+		property = name
+	}
+
+	switch property {
+	case "og:title":
+		openGraph.set("Title", content)
+	case "og:description":
+		openGraph.set("Description", content)
+	case "og:url":
+		openGraph.set("URL", content)
+	case "og:site_name":
+		openGraph.set("SiteName", content)
+	case "og:type":
+		openGraph.set("Type", content)
+	case "og:image", "og:image:url", "og:image:secure_url":
+		openGraph.set("Image", content)
+	case "og:video", "og:video:url", "og:video:secure_url":
+		openGraph.set("Video", content)
+	case "og:locale":
+		openGraph.set("Locale", content)
+	case "article:author":
+		openGraph.set("Author", content)
+	case "article:published_time":
+		openGraph.set("Published", content)
+	case "article:modified_time":
+		openGraph.set("Modified", content)
+	case "twitter:title":
+		twitter.set("Title", content)
+	case "twitter:description":
+		twitter.set("Description", content)
+	case "twitter:url":
+		twitter.set("URL", content)
+	case "twitter:site":
+		twitter.set("SiteName", content)
+	case "twitter:image", "twitter:image:src":
+		twitter.set("Image", content)
+	case "twitter:player":
+		twitter.set("Video", content)
+	case "twitter:creator":
+		twitter.set("Author", content)
+	case "twitter:card":
+		twitter.set("TwitterCard", content)
+	}
+
+	switch name {
+	case "description":
+		fallback.set("Description", content)
+	case "author":
+		fallback.set("Author", content)
+	case "date", "pubdate", "publish-date", "dc.date", "dc.date.issued":
+		fallback.set("Published", content)
+	}
+}
+
+// parseJSONLD finds the first supported schema.org object in a JSON-LD script, which may be a single
+// object, an array of objects or an object with a @graph, and returns its properties
+func parseJSONLD(script string) pageMetadataSource {
+	var data interface{}
+	if err := json.Unmarshal([]byte(script), &data); err != nil {
+		return nil
+	}
+
+	var candidates []interface{}
+	switch value := data.(type) {
+	case []interface{}:
+		candidates = value
+	case map[string]interface{}:
+		candidates = []interface{}{value}
+		if graph, ok := value["@graph"].([]interface{}); ok {
+			candidates = append(candidates, graph...)
+		}
+	}
+
+	for _, candidate := range candidates {
+		object, ok := candidate.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		objectType := jsonLDSupportedType(object["@type"])
+		if len(objectType) == 0 {
+			continue
+		}
+
+		result := make(pageMetadataSource)
+		result.set("Type", objectType)
+		result.set("Title", jsonLDText(object["headline"]))
+		result.set("Title", jsonLDText(object["name"]))
+		result.set("Description", jsonLDText(object["description"]))
+		result.set("URL", jsonLDText(object["url"]))
+		result.set("Image", jsonLDText(object["image"]))
+		result.set("Image", jsonLDText(object["thumbnailUrl"]))
+		result.set("Video", jsonLDText(object["contentUrl"]))
+		result.set("Video", jsonLDText(object["embedUrl"]))
+		result.set("Author", jsonLDText(object["author"]))
+		result.set("Published", jsonLDText(object["datePublished"]))
+		result.set("Published", jsonLDText(object["uploadDate"]))
+		result.set("Modified", jsonLDText(object["dateModified"]))
+		result.set("Locale", jsonLDText(object["inLanguage"]))
+		if publisher, ok := object["publisher"].(map[string]interface{}); ok {
+			result.set("SiteName", jsonLDText(publisher["name"]))
+		}
+		return result
+	}
+	return nil
+}
+
+// jsonLDSupportedType returns the @type (which may be a list of types) if it's one we support
+func jsonLDSupportedType(value interface{}) string {
+	switch objectType := value.(type) {
+	case string:
+		if jsonLDTypes[objectType] {
+			return objectType
+		}
+	case []interface{}:
+		for _, t := range objectType {
+			if name, ok := t.(string); ok && jsonLDTypes[name] {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// jsonLDText turns a JSON-LD value into text; values may be plain strings, objects
+// (e.g. a Person or ImageObject) or lists of either, in which case the first is used
+func jsonLDText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		if len(v) > 0 {
+			return jsonLDText(v[0])
+		}
+	case map[string]interface{}:
+		for _, key := range []string{"name", "url", "@id"} {
+			if text := jsonLDText(v[key]); len(text) > 0 {
+				return text
+			}
+		}
+	}
+	return ""
+}
+
+// resolveMetadataURL resolves a relative URL found in metadata against the page's base URL
+func resolveMetadataURL(urlText string, baseURL *url.URL) string {
+	if len(urlText) == 0 || baseURL == nil {
+		return urlText
+	}
+	resolved, err := baseURL.Parse(urlText)
+	if err != nil {
+		return urlText
+	}
+	return resolved.String()
+}
+
+// metadataDateFormats are the date formats commonly found in page metadata
+var metadataDateFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// parseMetadataDate makes a best effort to parse a date, returning the zero time if it can't
+func parseMetadataDate(text string) time.Time {
+	for _, format := range metadataDateFormats {
+		if date, err := time.Parse(format, text); err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+// nodeText returns all the text inside a node
+func nodeText(n *html.Node) string {
+	var text strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return text.String()
+}
@@ -0,0 +1,56 @@
+package harvester
+
+import (
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/html"
+)
+
+type MetadataSuite struct {
+	suite.Suite
+}
+
+func (suite *MetadataSuite) parseFixture(fileName string) *PageMetadata {
+	file, err := os.Open(fileName)
+	suite.NoError(err, "Fixture %s should exist", fileName)
+	defer file.Close()
+	doc, err := html.Parse(file)
+	suite.NoError(err, "Fixture %s should be valid HTML", fileName)
+	pageURL, _ := url.Parse("https://example.com/news/markets.html")
+	return ParsePageMetadata(doc, pageURL)
+}
+
+func (suite *MetadataSuite) TestMergedSources() {
+	metadata := suite.parseFixture("testdata/metadata/news-article.html")
+	suite.Equal(metadata.Title, "Markets Rally on Rate News")
+	suite.Equal(metadata.Sources["Title"], MetadataOpenGraph)
+	suite.Equal(metadata.Description, "The JSON-LD description.")
+	suite.Equal(metadata.Sources["Description"], MetadataJSONLD)
+	suite.Equal(metadata.Type, "article")
+	suite.Equal(metadata.Image, "https://example.com/images/markets.jpg", "Relative image URLs should be resolved")
+	suite.Equal(metadata.Author, "Jane Reporter")
+	suite.Equal(metadata.SiteName, "Example Times")
+	suite.Equal(metadata.PublishedDate, time.Date(2018, 5, 1, 9, 30, 0, 0, time.UTC))
+	suite.Equal(metadata.ModifiedDate, time.Date(2018, 5, 2, 0, 0, 0, 0, time.UTC))
+	suite.Equal(metadata.TwitterCard, "summary_large_image")
+	suite.Equal(metadata.Sources["TwitterCard"], MetadataTwitterCard)
+	suite.Equal(metadata.Locale, "en-GB")
+	suite.Equal(metadata.Heading, "Markets Rally")
+}
+
+func (suite *MetadataSuite) TestHTMLFallbacks() {
+	metadata := suite.parseFixture("testdata/metadata/html-only.html")
+	suite.Equal(metadata.Title, "A Post Without a Title", "The first <h1> is used when there's no <title>")
+	suite.Equal(metadata.Sources["Title"], MetadataHTMLFallback)
+	suite.Equal(metadata.Author, "Sam Blogger")
+	suite.Equal(metadata.PublishedDate, time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC))
+	suite.Empty(metadata.Description)
+}
+
+func TestMetadataSuite(t *testing.T) {
+	suite.Run(t, new(MetadataSuite))
+}
@@ -0,0 +1,77 @@
+package harvester
+
+import (
+	"net/url"
+	"time"
+)
+
+// ProvenanceKind is the kind of source that harvested content came from
+type ProvenanceKind string
+
+const (
+	// UnknownProvenance is used when the caller didn't say where content came from
+	UnknownProvenance ProvenanceKind = ""
+
+	// TweetProvenance is the text of a tweet
+	TweetProvenance ProvenanceKind = "tweet"
+
+	// EmailProvenance is the body of an e-mail message
+	EmailProvenance ProvenanceKind = "email"
+
+	// WebPageProvenance is the content of a web page
+	WebPageProvenance ProvenanceKind = "web page"
+
+	// FeedItemProvenance is an item (or entry) in an RSS, Atom or JSON feed
+	FeedItemProvenance ProvenanceKind = "feed item"
+
+	// ChatMessageProvenance is a message in a Slack or Discord export
+	ChatMessageProvenance ProvenanceKind = "chat message"
+
+	// BookmarksProvenance is a browser's bookmarks file
+	BookmarksProvenance ProvenanceKind = "bookmarks"
+)
+
+// EmbedStyle is how a URL (or the content itself) was embedded in its source
+type EmbedStyle string
+
+// Embed styles recorded for each occurrence of a URL, by the built-in Discoverer or an adapter
+const (
+	PlainTextEmbed         EmbedStyle = "text"
+	HTMLAnchorEmbed        EmbedStyle = "HTML <a>"
+	HTMLImageEmbed         EmbedStyle = "HTML <img>"
+	HTMLLinkEmbed          EmbedStyle = "HTML <link>"
+	MarkdownLinkEmbed      EmbedStyle = "Markdown link"
+	MarkdownImageEmbed     EmbedStyle = "Markdown image"
+	MarkdownAutolinkEmbed  EmbedStyle = "Markdown autolink"
+	MarkdownReferenceEmbed EmbedStyle = "Markdown reference"
+	FeedItemLinkEmbed      EmbedStyle = "feed item link"
+	SlackLinkEmbed         EmbedStyle = "Slack link"
+	ChatUnfurlEmbed        EmbedStyle = "chat unfurl"
+	BookmarkEmbed          EmbedStyle = "bookmark"
+)
+
+// Provenance records where harvested content came from, e.g. the tweet or e-mail it was in. It's
+// given with the ContentSource and carried on HarvestedResources and on every HarvestedResource;
+// each resource's copy has the EmbedStyle of the URL's first occurrence (e.g. HTMLAnchorEmbed).
+type Provenance struct {
+	Kind       ProvenanceKind
+	SourceID   string    // the source's own identifier, e.g. a tweet ID or e-mail Message-ID
+	Title      string    // the source's title, e.g. a feed item's title or an e-mail's subject
+	Author     string    // who wrote the source content
+	Channel    string    // the chat channel a message was posted in
+	Timestamp  time.Time // when the source content was created or sent
+	SourceURL  *url.URL  // where the source content can be viewed, e.g. the tweet's URL
+	EmbedStyle EmbedStyle
+}
+
+// resourceProvenance returns the provenance of a resource found in content from source
+func resourceProvenance(source *Provenance, firstOccurrence *DiscoveredURL) *Provenance {
+	result := new(Provenance)
+	if source != nil {
+		*result = *source
+	}
+	if firstOccurrence != nil && len(firstOccurrence.EmbedStyle) > 0 {
+		result.EmbedStyle = firstOccurrence.EmbedStyle
+	}
+	return result
+}
@@ -0,0 +1,312 @@
+package harvester
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// RedirectType identifies the mechanism through which a redirect was requested.
+// For an explanation, please see http://redirectdetective.com/redirection-types.html
+type RedirectType string
+
+const (
+	// HTTPRedirect is a server-side 3xx response with a Location header
+	HTTPRedirect RedirectType = "HTTP"
+
+	// HTMLMetaRefreshRedirect is a client-side <meta http-equiv='refresh' content='delay;url='>
+	HTMLMetaRefreshRedirect RedirectType = "HTML meta refresh"
+
+	// HTTPRefreshHeaderRedirect is a client-side redirect requested by a 'Refresh: delay;url=' response header
+	HTTPRefreshHeaderRedirect RedirectType = "HTTP Refresh header"
+
+	// JavaScriptRedirect is a client-side redirect requested by a script assigning to
+	// window.location (or similar) or calling location.replace() or location.assign()
+	JavaScriptRedirect RedirectType = "JavaScript"
+)
+
+// javaScriptRedirectRegExList matches scripts that navigate to a string literal such as
+// window.location = "https://www.google.com" or location.replace('https://www.google.com').
+// Scripts are never executed so only literal URLs can be detected.
+var javaScriptRedirectRegExList = []*regexp.Regexp{
+	regexp.MustCompile(`\b(?:(?:window|document|top|self)\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']`),
+	regexp.MustCompile(`\blocation(?:\.href)?\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`),
+}
+
+// RedirectHop is a single step in the chain of redirects followed to reach a resource
+type RedirectHop struct {
+	URL        *url.URL
+	StatusCode int
+	Method     string
+	Location   string
+	Timestamp  time.Time
+	Type       RedirectType
+}
+
+// httpRedirectChain walks back from the final response through each redirect response
+// that led to it, returning the hops in the order they were followed. Go's http.Client
+// doesn't record when each hop happened so the hop's Date header is used, if available.
+func httpRedirectChain(resp *http.Response, harvestedDate time.Time) []*RedirectHop {
+	var chain []*RedirectHop
+	if resp == nil || resp.Request == nil {
+		return chain
+	}
+
+	for prev := resp.Request.Response; prev != nil && prev.Request != nil; prev = prev.Request.Response {
+		chain = append([]*RedirectHop{httpRedirectHop(prev, harvestedDate)}, chain...)
+	}
+	return chain
+}
+
+// httpRedirectHop records a single 3xx response
+func httpRedirectHop(resp *http.Response, harvestedDate time.Time) *RedirectHop {
+	hop := new(RedirectHop)
+	hop.URL = resp.Request.URL
+	hop.StatusCode = resp.StatusCode
+	hop.Method = resp.Request.Method
+	hop.Location = resp.Header.Get("Location")
+	hop.Timestamp = harvestedDate
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		hop.Timestamp = date
+	}
+	hop.Type = HTTPRedirect
+	return hop
+}
+
+// findRedirectLoop returns the first URL that appears more than once in the chain
+func findRedirectLoop(chain []*RedirectHop) (bool, string) {
+	visited := make(map[string]bool)
+	for _, hop := range chain {
+		if hop.URL == nil {
+			continue
+		}
+		urlText := hop.URL.String()
+		if visited[urlText] {
+			return true, urlText
+		}
+		visited[urlText] = true
+	}
+	return false, ""
+}
+
+// htmlRedirectHop records the page that requested a client-side redirect to another URL
+func htmlRedirectHop(referrer *HarvestedResource) *RedirectHop {
+	hop := new(RedirectHop)
+	hop.URL = referrer.resolvedURL
+	hop.StatusCode = referrer.httpStatusCode
+	hop.Method = http.MethodGet
+	hop.Location = referrer.htmlRedirectURL
+	hop.Timestamp = referrer.harvestedDate
+	hop.Type = referrer.htmlRedirectType
+	return hop
+}
+
+// findJavaScriptRedirect looks through inline scripts for a literal navigation
+func findJavaScriptRedirect(doc *html.Node) (bool, string) {
+	var redirectURL string
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if len(redirectURL) > 0 {
+			return
+		}
+		if n.Type == html.ElementNode && strings.EqualFold(n.Data, "script") && n.FirstChild != nil {
+			for _, regEx := range javaScriptRedirectRegExList {
+				parts := regEx.FindStringSubmatch(n.FirstChild.Data)
+				if parts != nil {
+					redirectURL = parts[1]
+					return
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	return len(redirectURL) > 0, redirectURL
+}
+
+// RefreshDirective is a parsed meta refresh 'content' attribute or 'Refresh' HTTP header value
+type RefreshDirective struct {
+	Delay time.Duration
+	URL   *url.URL
+}
+
+// ParseRefreshContent parses a meta refresh 'content' attribute or 'Refresh' HTTP header value following
+// the HTML specification's shared declarative refresh steps, resolving a relative URL against baseURL.
+// It returns nil if the value isn't a valid refresh directive; the directive's URL is nil if the value
+// only has a delay (meaning the page refreshes itself rather than redirecting elsewhere).
+// See https://html.spec.whatwg.org/multipage/semantics.html#shared-declarative-refresh-steps
+func ParseRefreshContent(value string, baseURL *url.URL) *RefreshDirective {
+	isWhitespace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+	}
+	isDigit := func(c byte) bool {
+		return c >= '0' && c <= '9'
+	}
+	pos := 0
+	skipWhitespace := func() {
+		for pos < len(value) && isWhitespace(value[pos]) {
+			pos++
+		}
+	}
+	nextIs := func(lower byte) bool {
+		if pos < len(value) && (value[pos] == lower || value[pos] == lower-'a'+'A') {
+			pos++
+			return true
+		}
+		return false
+	}
+
+	skipWhitespace()
+	start := pos
+	for pos < len(value) && isDigit(value[pos]) {
+		pos++
+	}
+	timeText := value[start:pos]
+	if len(timeText) == 0 && (pos >= len(value) || value[pos] != '.') {
+		return nil
+	}
+	result := new(RefreshDirective)
+	if len(timeText) > 0 {
+		seconds, err := strconv.ParseInt(timeText, 10, 32)
+		if err != nil {
+			// the delay is too big to matter, anything this large will never refresh
+			seconds = math.MaxInt32
+		}
+		result.Delay = time.Duration(seconds) * time.Second
+	}
+	// fractional seconds are allowed but ignored
+	for pos < len(value) && (isDigit(value[pos]) || value[pos] == '.') {
+		pos++
+	}
+
+	if pos < len(value) {
+		if value[pos] != ';' && value[pos] != ',' && !isWhitespace(value[pos]) {
+			return nil
+		}
+		skipWhitespace()
+		if pos < len(value) && (value[pos] == ';' || value[pos] == ',') {
+			pos++
+		}
+		skipWhitespace()
+	}
+	if pos >= len(value) {
+		return result
+	}
+
+	urlText := value[pos:]
+	if nextIs('u') {
+		if !nextIs('r') || !nextIs('l') {
+			return result.resolve(urlText, baseURL)
+		}
+		skipWhitespace()
+		if pos >= len(value) || value[pos] != '=' {
+			return result.resolve(urlText, baseURL)
+		}
+		pos++
+		skipWhitespace()
+	}
+
+	var quote byte
+	if pos < len(value) && (value[pos] == '"' || value[pos] == '\'') {
+		quote = value[pos]
+		pos++
+	}
+	urlText = value[pos:]
+	if quote != 0 {
+		if end := strings.IndexByte(urlText, quote); end >= 0 {
+			urlText = urlText[:end]
+		}
+	}
+	return result.resolve(urlText, baseURL)
+}
+
+// resolve finishes parsing a refresh directive by resolving its URL against baseURL
+func (d *RefreshDirective) resolve(urlText string, baseURL *url.URL) *RefreshDirective {
+	refreshURL, err := resolveURL(strings.TrimSpace(urlText), baseURL)
+	if err != nil {
+		return nil
+	}
+	d.URL = refreshURL
+	return d
+}
+
+// resolveURL parses urlText as a URL, relative to baseURL if baseURL is not nil
+func resolveURL(urlText string, baseURL *url.URL) (*url.URL, error) {
+	if baseURL == nil {
+		return url.Parse(urlText)
+	}
+	return baseURL.Parse(urlText)
+}
+
+// findBaseURL returns the URL relative URLs in the document are resolved against, which is
+// the first <base href> (itself resolved against the page's URL) or else the page's URL
+func findBaseURL(doc *html.Node, pageURL *url.URL) *url.URL {
+	var baseHref string
+	var found bool
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if found {
+			return
+		}
+		if n.Type == html.ElementNode && strings.EqualFold(n.Data, "base") {
+			for _, attr := range n.Attr {
+				if strings.EqualFold(attr.Key, "href") {
+					baseHref = strings.TrimSpace(attr.Val)
+					found = true
+					return
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+
+	if found {
+		if baseURL, err := resolveURL(baseHref, pageURL); err == nil {
+			return baseURL
+		}
+	}
+	return pageURL
+}
+
+// clientRedirect is a client-side (not HTTP 3xx) redirect requested by a page
+type clientRedirect struct {
+	url          *url.URL
+	redirectType RedirectType
+	delay        time.Duration
+}
+
+// findClientRedirect looks for a meta refresh tag and then (without executing anything)
+// inline JavaScript location changes in the document found at pageURL
+func findClientRedirect(doc *html.Node, pageURL *url.URL) *clientRedirect {
+	baseURL := findBaseURL(doc, pageURL)
+
+	mn := findMetaRefreshTagInHead(doc)
+	if mn != nil {
+		for _, attr := range mn.Attr {
+			if strings.EqualFold(attr.Key, "content") {
+				if refresh := ParseRefreshContent(attr.Val, baseURL); refresh != nil && refresh.URL != nil {
+					return &clientRedirect{refresh.URL, HTMLMetaRefreshRedirect, refresh.Delay}
+				}
+			}
+		}
+	}
+
+	if isScripted, scriptedURLText := findJavaScriptRedirect(doc); isScripted {
+		if scriptedURL, err := resolveURL(scriptedURLText, baseURL); err == nil {
+			return &clientRedirect{scriptedURL, JavaScriptRedirect, 0}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package harvester
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/html"
+)
+
+type RedirectSuite struct {
+	suite.Suite
+	pageURL *url.URL
+}
+
+func (suite *RedirectSuite) SetupSuite() {
+	suite.pageURL, _ = url.Parse("https://example.com/dir/page.html")
+}
+
+func (suite *RedirectSuite) TestClientRedirectFixtures() {
+	fixtures := []struct {
+		file         string
+		redirectType RedirectType
+		delay        time.Duration
+		redirectURL  string
+	}{
+		{"sample-html-with-meta-refresh.html", HTMLMetaRefreshRedirect, 2 * time.Second, "https://www.sopranodesign.com/secure-healthcare-messaging/?utm_source=twitter&utm_medium=socialmedia&utm_campaign=soprano"},
+		{"testdata/refresh/delay-over-nine.html", HTMLMetaRefreshRedirect, 15 * time.Second, "https://example.com/article"},
+		{"testdata/refresh/uppercase-url.html", HTMLMetaRefreshRedirect, 0, "https://example.com/article"},
+		{"testdata/refresh/quoted-url.html", HTMLMetaRefreshRedirect, 3 * time.Second, "https://example.com/quoted?a=1&b=2"},
+		{"testdata/refresh/comma-separator.html", HTMLMetaRefreshRedirect, 1 * time.Second, "https://example.com/dir/next.html"},
+		{"testdata/refresh/extra-whitespace.html", HTMLMetaRefreshRedirect, 5 * time.Second, "https://example.com/spaced"},
+		{"testdata/refresh/base-href.html", HTMLMetaRefreshRedirect, 0, "https://cdn.example.org/base/landing.html"},
+		{"testdata/refresh/no-url-prefix.html", HTMLMetaRefreshRedirect, 2 * time.Second, "https://example.com/bare"},
+		{"testdata/refresh/fractional-delay.html", HTMLMetaRefreshRedirect, 1 * time.Second, "https://example.com/fraction"},
+		{"testdata/refresh/delay-only.html", "", 0, ""},
+		{"testdata/refresh/invalid-delay.html", "", 0, ""},
+		{"testdata/refresh/javascript.html", JavaScriptRedirect, 0, "https://example.com/js-target"},
+	}
+
+	for _, fixture := range fixtures {
+		file, err := os.Open(fixture.file)
+		suite.NoError(err, "Fixture %s should exist", fixture.file)
+		doc, err := html.Parse(file)
+		file.Close()
+		suite.NoError(err, "Fixture %s should be valid HTML", fixture.file)
+
+		redirect := findClientRedirect(doc, suite.pageURL)
+		if len(fixture.redirectURL) == 0 {
+			suite.Nil(redirect, "Fixture %s should not redirect", filepath.Base(fixture.file))
+			continue
+		}
+		if suite.NotNil(redirect, "Fixture %s should redirect", filepath.Base(fixture.file)) {
+			suite.Equal(fixture.redirectType, redirect.redirectType, fixture.file)
+			suite.Equal(fixture.delay, redirect.delay, fixture.file)
+			suite.Equal(fixture.redirectURL, redirect.url.String(), fixture.file)
+		}
+	}
+}
+
+func (suite *RedirectSuite) TestRefreshHeaderContent() {
+	refresh := ParseRefreshContent("10;url=\"/moved\"", suite.pageURL)
+	suite.Equal(10*time.Second, refresh.Delay)
+	suite.Equal("https://example.com/moved", refresh.URL.String())
+
+	refresh = ParseRefreshContent("0", suite.pageURL)
+	suite.Nil(refresh.URL, "A delay without a URL refreshes the same page")
+
+	suite.Nil(ParseRefreshContent("", suite.pageURL), "An empty value isn't a refresh")
+	suite.Nil(ParseRefreshContent("5x;url=/moved", suite.pageURL), "Garbage after the delay isn't a refresh")
+}
+
+func TestRedirectSuite(t *testing.T) {
+	suite.Run(t, new(RedirectSuite))
+}
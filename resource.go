@@ -1,6 +1,8 @@
 package harvester
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,7 +10,6 @@ import (
 	"net/url"
 	"os"
 	"path"
-	"regexp"
 	"strings"
 	"time"
 
@@ -34,6 +35,19 @@ func (dc *DownloadedContent) Delete() {
 // DownloadContent will download a url to a local file. It's efficient because it will
 // write as it downloads and not load the whole file into memory.
 func DownloadContent(url *url.URL, resp *http.Response) *DownloadedContent {
+	return DownloadContentContext(context.Background(), url, resp)
+}
+
+// DownloadContentContext is like DownloadContent but stops downloading (and
+// records ctx.Err() as the DownloadError) when ctx is cancelled or expires.
+func DownloadContentContext(ctx context.Context, url *url.URL, resp *http.Response) *DownloadedContent {
+	defer resp.Body.Close()
+	return saveDownloadedContent(url, &contextReader{ctx, resp.Body})
+}
+
+// saveDownloadedContent writes content to a temporary file and detects its file type; the
+// file's extension is changed to match the type
+func saveDownloadedContent(url *url.URL, content io.Reader) *DownloadedContent {
 	destFile, err := ioutil.TempFile(os.TempDir(), "ContentHarvester-")
 
 	result := new(DownloadedContent)
@@ -44,9 +58,8 @@ func DownloadContent(url *url.URL, resp *http.Response) *DownloadedContent {
 	}
 
 	defer destFile.Close()
-	defer resp.Body.Close()
 	result.DestPath = destFile.Name()
-	_, err = io.Copy(destFile, resp.Body)
+	_, err = io.Copy(destFile, content)
 	if err != nil {
 		result.DownloadError = err
 		return result
@@ -85,6 +98,11 @@ type HarvestedResourceContent struct {
 	MediaType       string
 	MediaTypeParams map[string]string
 	MediaTypeError  error
+	HTML            []byte
+	HTMLTruncated   bool
+	HTMLReadError   error
+	Metadata        *PageMetadata
+	Article         *Article
 	Downloaded      *DownloadedContent
 }
 
@@ -94,6 +112,10 @@ func (c *HarvestedResourceContent) IsValid() bool {
 		return false
 	}
 
+	if c.HTMLReadError != nil {
+		return false
+	}
+
 	if c.Downloaded != nil {
 		if c.Downloaded.DownloadError != nil {
 			return false
@@ -111,37 +133,56 @@ func (c *HarvestedResourceContent) IsHTML() bool {
 	return c.MediaType == "text/html"
 }
 
+// readHTML reads up to maxSize bytes of an HTML body so that it can be parsed (and re-parsed
+// later, e.g. for page metadata) without another request; the rest of the body is discarded
+func readHTML(ctx context.Context, body io.Reader, maxSize int64) ([]byte, bool, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(&contextReader{ctx, body}, maxSize+1))
+	if int64(len(data)) > maxSize {
+		return data[:maxSize], true, err
+	}
+	return data, false, err
+}
+
 // WasDownloaded returns true if content was downloaded for inspection
 func (c *HarvestedResourceContent) WasDownloaded() bool {
 	return c.Downloaded != nil
 }
 
-// metaRefreshContentRegEx is used to match the 'content' attribute in a tag like this:
-//   <meta http-equiv="refresh" content="2;url=https://www.google.com">
-var metaRefreshContentRegEx = regexp.MustCompile(`^(\d?)\s?;\s?url=(.*)$`)
-
 // HarvestedResource tracks a single URL that was discovered in content.
 // Discovered URLs are validated, follow their redirects, and may have
 // query parameters "cleaned" (if instructed).
 type HarvestedResource struct {
-	// TODO consider adding source information (e.g. tweet, e-mail, etc.) and embed style (e.g. text, HTML <a> tag, etc.)
-	harvestedDate   time.Time
-	origURLtext     string
-	origResource    *HarvestedResource
-	isURLValid      bool
-	isDestValid     bool
-	httpStatusCode  int
-	isURLIgnored    bool
-	ignoreReason    string
-	isURLCleaned    bool
-	isURLAttachment bool
-	isHTMLRedirect  bool
-	htmlRedirectURL string
-	htmlParseError  error
-	resolvedURL     *url.URL
-	cleanedURL      *url.URL
-	finalURL        *url.URL
-	resourceContent *HarvestedResourceContent
+	harvestedDate     time.Time
+	origURLtext       string
+	occurrences       []*DiscoveredURL
+	provenance        *Provenance
+	origResource      *HarvestedResource
+	isURLValid        bool
+	isDestValid       bool
+	httpStatusCode    int
+	httpStatusClass   HTTPStatusClass
+	httpStatusReason  string
+	fetchAttempts     []*FetchAttempt
+	isURLIgnored      bool
+	ignoreReason      string
+	isURLCleaned      bool
+	isCleanReverted   bool
+	cleanRevertReason string
+	isURLAttachment   bool
+	isHTMLRedirect    bool
+	htmlRedirectURL   string
+	htmlRedirectType  RedirectType
+	htmlRedirectDelay time.Duration
+	htmlParseError    error
+	resolvedURL       *url.URL
+	cleanedURL        *url.URL
+	finalURL          *url.URL
+	canonicalURL      *url.URL
+	canonicalSource   string
+	redirectChain     []*RedirectHop
+	redirectStopped   bool
+	redirectReason    string
+	resourceContent   *HarvestedResourceContent
 }
 
 // OriginalURLText returns the URL as it was discovered, with no alterations
@@ -149,6 +190,35 @@ func (r *HarvestedResource) OriginalURLText() string {
 	return r.origURLtext
 }
 
+// DiscoveredURL returns where and how the URL was first found in the content, including the
+// URL's text before it was resolved against the base URL and its anchor text
+func (r *HarvestedResource) DiscoveredURL() *DiscoveredURL {
+	if len(r.occurrences) == 0 {
+		return nil
+	}
+	return r.occurrences[0]
+}
+
+// Occurrences returns every place the URL was found in the content, in order; a URL
+// that appears more than once is only harvested once
+func (r *HarvestedResource) Occurrences() []*DiscoveredURL {
+	return r.occurrences
+}
+
+// Provenance returns where the content the URL was discovered in came from, with the
+// EmbedStyle of the URL's first occurrence
+func (r *HarvestedResource) Provenance() *Provenance {
+	return r.provenance
+}
+
+// AnchorText returns the link text the URL was first discovered with in HTML or Markdown content
+func (r *HarvestedResource) AnchorText() string {
+	if len(r.occurrences) == 0 {
+		return ""
+	}
+	return r.occurrences[0].AnchorText
+}
+
 // ReferredByResource returns the original resource that referred this one,
 // which is only non-nil when this resource was an HTML (not HTTP) redirect
 func (r *HarvestedResource) ReferredByResource() *HarvestedResource {
@@ -161,6 +231,30 @@ func (r *HarvestedResource) IsValid() (bool, bool) {
 	return r.isURLValid, r.isDestValid
 }
 
+// HTTPStatus returns the destination's HTTP status code (0 if there was no response), how the
+// harvester's HTTPStatusPolicy classified it and, unless it's valid, the reason it wasn't harvested
+func (r *HarvestedResource) HTTPStatus() (int, HTTPStatusClass, string) {
+	return r.httpStatusCode, r.httpStatusClass, r.httpStatusReason
+}
+
+// IsRetryable indicates whether the destination was temporarily unavailable (e.g. throttled with
+// 429 Too Many Requests), in which case harvesting it again later may work, and why
+func (r *HarvestedResource) IsRetryable() (bool, string) {
+	return r.httpStatusClass == RetryableHTTPStatus, r.httpStatusReason
+}
+
+// IsRestricted indicates whether the destination exists but requires authorization or payment
+// (e.g. a 401 Unauthorized or 403 Forbidden paywall), and why; its finalURL is the URL that was reached
+func (r *HarvestedResource) IsRestricted() (bool, string) {
+	return r.httpStatusClass == RestrictedHTTPStatus, r.httpStatusReason
+}
+
+// FetchAttempts returns every request made for the URL, in order; there's more than one when
+// transient failures were retried (see RetryPolicy)
+func (r *HarvestedResource) FetchAttempts() []*FetchAttempt {
+	return r.fetchAttempts
+}
+
 // IsIgnored indicates whether the URL should be ignored based on harvesting rules.
 // Discovered URLs may be ignored for a variety of reasons using a list of Regexps.
 func (r *HarvestedResource) IsIgnored() (bool, string) {
@@ -172,17 +266,52 @@ func (r *HarvestedResource) IsCleaned() (bool, *url.URL) {
 	return r.isURLCleaned, r.cleanedURL
 }
 
+// IsCleaningReverted indicates whether the cleaned URL failed verification, in which case
+// the resolved (uncleaned) URL is used as the finalURL, and why
+func (r *HarvestedResource) IsCleaningReverted() (bool, string) {
+	return r.isCleanReverted, r.cleanRevertReason
+}
+
 // GetURLs returns the final (most useful), originally resolved, and "cleaned" URLs
 func (r *HarvestedResource) GetURLs() (*url.URL, *url.URL, *url.URL) {
 	return r.finalURL, r.resolvedURL, r.cleanedURL
 }
 
-// IsHTMLRedirect returns true if redirect was requested through via <meta http-equiv='refresh' content='delay;url='>
+// IsHTMLRedirect returns true if a client-side redirect was requested through <meta http-equiv='refresh' content='delay;url='>,
+// a 'Refresh' HTTP header or a JavaScript location change; HTMLRedirectType tells which one was used.
 // For an explanation, please see http://redirectdetective.com/redirection-types.html
 func (r *HarvestedResource) IsHTMLRedirect() (bool, string) {
 	return r.isHTMLRedirect, r.htmlRedirectURL
 }
 
+// HTMLRedirectType returns the mechanism used to request the client-side redirect, if IsHTMLRedirect
+func (r *HarvestedResource) HTMLRedirectType() RedirectType {
+	return r.htmlRedirectType
+}
+
+// HTMLRedirectDelay returns how long the page asked to wait before the client-side redirect
+func (r *HarvestedResource) HTMLRedirectDelay() time.Duration {
+	return r.htmlRedirectDelay
+}
+
+// CanonicalURL returns the canonical URL the destination declared for itself and where it was
+// declared (e.g. CanonicalLinkElement); it's nil unless the harvester's CanonicalURLPolicy looks for it
+func (r *HarvestedResource) CanonicalURL() (*url.URL, string) {
+	return r.canonicalURL, r.canonicalSource
+}
+
+// RedirectChain returns every redirect (HTTP or HTML) followed, in order, from the
+// originally discovered URL to the resolved URL; it's empty if there were no redirects
+func (r *HarvestedResource) RedirectChain() []*RedirectHop {
+	return r.redirectChain
+}
+
+// IsRedirectStopped indicates whether following redirects was stopped before reaching a final
+// destination, either because a redirect loop was detected or too many redirects were requested
+func (r *HarvestedResource) IsRedirectStopped() (bool, string) {
+	return r.redirectStopped, r.redirectReason
+}
+
 // ResourceContent returns the inspected or downloaded content
 func (r *HarvestedResource) ResourceContent() *HarvestedResourceContent {
 	return r.resourceContent
@@ -245,42 +374,45 @@ func findMetaRefreshTagInHead(doc *html.Node) *html.Node {
 	return metaTag
 }
 
+// getClientRedirect detects client-side redirects, checking (in order) a Refresh HTTP header,
+// a meta refresh tag and (without executing anything) inline JavaScript location changes;
+// doc is nil if the response wasn't HTML.
 // See for explanation: http://redirectdetective.com/redirection-types.html
-func getMetaRefresh(resp *http.Response) (bool, string, error) {
-	doc, parseError := html.Parse(resp.Body)
-	if parseError != nil {
-		return false, "", parseError
-	}
-	defer resp.Body.Close()
-
-	mn := findMetaRefreshTagInHead(doc)
-	if mn == nil {
-		return false, "", nil
+func getClientRedirect(resp *http.Response, doc *html.Node) *clientRedirect {
+	pageURL := resp.Request.URL
+	if refresh := ParseRefreshContent(resp.Header.Get("Refresh"), pageURL); refresh != nil && refresh.URL != nil {
+		return &clientRedirect{refresh.URL, HTTPRefreshHeaderRedirect, refresh.Delay}
 	}
-
-	for _, attr := range mn.Attr {
-		if strings.EqualFold(attr.Key, "content") {
-			contentValue := strings.TrimSpace(attr.Val)
-			parts := metaRefreshContentRegEx.FindStringSubmatch(contentValue)
-			if parts != nil && len(parts) == 3 {
-				// the first part is the entire match
-				// the second and third parts are the delay and URL
-				return true, parts[2], nil
-			}
-		}
+	if doc == nil {
+		return nil
 	}
-
-	return false, "", nil
+	return findClientRedirect(doc, pageURL)
 }
 
-func harvestResource(h *ContentHarvester, origURLtext string) *HarvestedResource {
+func harvestResource(ctx context.Context, h *ContentHarvester, origURLtext string) *HarvestedResource {
 	result := new(HarvestedResource)
 	result.origURLtext = origURLtext
 	result.harvestedDate = time.Now()
 
-	// Use the standard Go HTTP library method to retrieve the content; the
-	// default will automatically follow redirects (e.g. HTTP redirects)
-	resp, err := http.Get(origURLtext)
+	// Use the harvester's fetcher to retrieve the content; the default
+	// will automatically follow redirects (e.g. HTTP redirects)
+	resp, attempts, err := h.fetchWithRetries(ctx, origURLtext)
+	result.fetchAttempts = attempts
+	if err != nil && resp != nil {
+		// the fetcher refused to follow a redirect (e.g. too many or a loop); resp is the last 3xx it received
+		result.isURLValid = true
+		result.isDestValid = false
+		result.isURLIgnored = true
+		result.redirectChain = append(httpRedirectChain(resp, result.harvestedDate), httpRedirectHop(resp, result.harvestedDate))
+		result.redirectStopped = true
+		if isLoop, loopURL := findRedirectLoop(result.redirectChain); isLoop {
+			result.redirectReason = fmt.Sprintf("HTTP redirect loop detected at '%s'", loopURL)
+		} else {
+			result.redirectReason = err.Error()
+		}
+		result.ignoreReason = result.redirectReason
+		return result
+	}
 	result.isURLValid = err == nil
 	if result.isURLValid == false {
 		result.isDestValid = false
@@ -289,11 +421,18 @@ func harvestResource(h *ContentHarvester, origURLtext string) *HarvestedResource
 		return result
 	}
 
+	result.redirectChain = httpRedirectChain(resp, result.harvestedDate)
 	result.httpStatusCode = resp.StatusCode
-	if result.httpStatusCode != 200 {
+	result.httpStatusClass = h.httpStatusPolicy.Classify(resp.StatusCode)
+	if result.httpStatusClass != ValidHTTPStatus {
+		resp.Body.Close()
 		result.isDestValid = false
-		result.isURLIgnored = true
-		result.ignoreReason = fmt.Sprintf("Invalid HTTP Status Code %d", resp.StatusCode)
+		result.httpStatusReason = httpStatusReason(resp.StatusCode, result.httpStatusClass)
+		if result.httpStatusClass != InvalidHTTPStatus {
+			// the destination exists, it just can't be harvested (yet)
+			result.resolvedURL = resp.Request.URL
+			result.finalURL = result.resolvedURL
+		}
 		return result
 	}
 
@@ -301,6 +440,7 @@ func harvestResource(h *ContentHarvester, origURLtext string) *HarvestedResource
 	result.finalURL = result.resolvedURL
 	ignoreURL, ignoreReason := h.ignoreResourceRule.IgnoreDiscoveredResource(result.resolvedURL)
 	if ignoreURL {
+		resp.Body.Close()
 		result.isDestValid = true
 		result.isURLIgnored = true
 		result.ignoreReason = ignoreReason
@@ -318,25 +458,114 @@ func harvestResource(h *ContentHarvester, origURLtext string) *HarvestedResource
 		result.isURLCleaned = false
 	}
 
-	result.resourceContent = h.detectResourceContent(result.finalURL, resp)
+	result.resourceContent = h.detectResourceContent(ctx, result.finalURL, resp)
+	resp.Body.Close()
+	var doc *html.Node
 	if result.resourceContent.IsHTML() {
-		result.isHTMLRedirect, result.htmlRedirectURL, result.htmlParseError = getMetaRefresh(resp)
+		doc, result.htmlParseError = html.Parse(bytes.NewReader(result.resourceContent.HTML))
+	}
+	if doc != nil {
+		result.resourceContent.Metadata = ParsePageMetadata(doc, result.resolvedURL)
+		if h.extractArticles {
+			result.resourceContent.Article = ExtractArticle(result.resourceContent.HTML, findBaseURL(doc, result.resolvedURL), result.resourceContent.Metadata)
+		}
+	}
+
+	if result.isURLCleaned && h.cleanedURLVerification != DontVerifyCleanedURL {
+		fingerprint := contentFingerprint(doc, result.resourceContent)
+		verified, reason := verifyCleanedURL(ctx, h, result.cleanedURL, result.httpStatusCode, fingerprint)
+		if !verified {
+			result.isURLCleaned = false
+			result.isCleanReverted = true
+			result.cleanRevertReason = reason
+			result.finalURL = result.resolvedURL
+			result.resourceContent.URL = result.resolvedURL
+		}
 	}
 
-	// TODO once the URL is cleaned, double-check the cleaned URL to see if it's a valid destination; if not, revert to non-cleaned version
-	// this could be done recursively here or by the outer function. This is necessary because "cleaning" a URL and removing params might
-	// break it so we need to revert to original.
+	redirect := getClientRedirect(resp, doc)
+	if redirect != nil {
+		result.isHTMLRedirect = true
+		result.htmlRedirectURL = redirect.url.String()
+		result.htmlRedirectType = redirect.redirectType
+		result.htmlRedirectDelay = redirect.delay
+	}
+
+	if h.canonicalURLPolicy != IgnoreCanonicalURL {
+		result.canonicalURL, result.canonicalSource = findCanonicalURL(resp, doc, result.resolvedURL)
+		if result.canonicalURL != nil && h.canonicalURLPolicy == PreferCanonicalURL {
+			// the canonical URL is subject to the same rules as the resolved URL
+			if ignoreCanonical, _ := h.ignoreResourceRule.IgnoreDiscoveredResource(result.canonicalURL); !ignoreCanonical {
+				result.finalURL = result.canonicalURL
+				if canonicalCleaned, cleanedCanonicalURL := cleanResource(result.canonicalURL, h.cleanResourceRule); canonicalCleaned {
+					result.finalURL = cleanedCanonicalURL
+				}
+				result.resourceContent.URL = result.finalURL
+			}
+		}
+	}
 
 	return result
 }
 
-func harvestResourceFromReferrer(h *ContentHarvester, original *HarvestedResource) *HarvestedResource {
+func harvestResourceFromReferrer(ctx context.Context, h *ContentHarvester, original *HarvestedResource) *HarvestedResource {
 	isHTMLRedirect, htmlRedirectURL := original.IsHTMLRedirect()
 	if !isHTMLRedirect {
 		return nil
 	}
 
-	result := harvestResource(h, htmlRedirectURL)
+	result := harvestResource(ctx, h, htmlRedirectURL)
 	result.origResource = original
+	result.occurrences = original.occurrences
+	result.provenance = original.provenance
+
+	chain := append([]*RedirectHop{}, original.redirectChain...)
+	chain = append(chain, htmlRedirectHop(original))
+	result.redirectChain = append(chain, result.redirectChain...)
 	return result
 }
+
+// harvestHTMLRedirects follows a chain of HTML (not HTTP) redirects starting at original, up to the
+// harvester's maximum number of hops. If a URL already visited through either HTTP or HTML redirects
+// comes up again the chain is a loop, so following stops at the last resource that wasn't repeated.
+func harvestHTMLRedirects(ctx context.Context, h *ContentHarvester, original *HarvestedResource) *HarvestedResource {
+	visited := make(map[string]bool)
+	visit := func(r *HarvestedResource) {
+		for _, hop := range r.redirectChain {
+			if hop.URL != nil {
+				visited[hop.URL.String()] = true
+			}
+		}
+		if r.resolvedURL != nil {
+			visited[r.resolvedURL.String()] = true
+		}
+	}
+
+	result := original
+	visit(result)
+	for hops := 0; ; hops++ {
+		isHTMLRedirect, htmlRedirectURL := result.IsHTMLRedirect()
+		if !isHTMLRedirect || ctx.Err() != nil {
+			return result
+		}
+		if hops >= h.maxHTMLRedirects {
+			result.redirectStopped = true
+			result.redirectReason = fmt.Sprintf("Stopped after %d HTML redirects", hops)
+			return result
+		}
+		if visited[htmlRedirectURL] {
+			result.redirectStopped = true
+			result.redirectReason = fmt.Sprintf("HTML redirect loop detected at '%s'", htmlRedirectURL)
+			return result
+		}
+
+		referredTo := harvestResourceFromReferrer(ctx, h, result)
+		if referredTo.resolvedURL != nil && visited[referredTo.resolvedURL.String()] {
+			result.redirectStopped = true
+			result.redirectReason = fmt.Sprintf("Redirect loop detected at '%s'", referredTo.resolvedURL.String())
+			return result
+		}
+		visit(referredTo)
+		result = referredTo
+	}
+}
@@ -1,14 +1,20 @@
 package harvester
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
@@ -31,7 +37,7 @@ func (suite *ResourceSuite) SetupSuite() {
 	suite.logger = logger
 	suite.ch = MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
 
-	tmpl, tmplErr := template.ParseFiles("serialize.md.tmpl")
+	tmpl, tmplErr := template.New("serialize.md.tmpl").Funcs(TemplateFuncs()).ParseFiles("serialize.md.tmpl")
 	if tmplErr != nil {
 		log.Fatalf("can't initialize template: %v", err)
 	}
@@ -65,7 +71,7 @@ func (suite *ResourceSuite) SetupSuite() {
 }
 
 func (suite *ResourceSuite) harvestSingleURLFromMockTweet(text string, msgAndArgs ...interface{}) *HarvestedResource {
-	suite.harvested = suite.ch.HarvestResources(fmt.Sprintf(text, msgAndArgs))
+	suite.harvested = suite.ch.HarvestResources(fmt.Sprintf(text, msgAndArgs...))
 	suite.Equal(len(suite.harvested.Resources), 1)
 	return suite.harvested.Resources[0]
 }
@@ -111,7 +117,7 @@ func (suite *ResourceSuite) TestResolvedURLRedirectedThroughHTMLProperly() {
 	suite.NotNil(hr.ResourceContent(), "Content should be available")
 
 	// at this point we want to get the "new" (redirected) and test it
-	redirectedHR := harvestResourceFromReferrer(suite.ch, hr)
+	redirectedHR := harvestResourceFromReferrer(context.Background(), suite.ch, hr)
 	suite.Equal(redirectedHR.ReferredByResource(), hr, "The referral resource should be the same as the original")
 	isURLValid, isDestValid = redirectedHR.IsValid()
 	suite.True(isURLValid, "Redirected URL should be formatted validly")
@@ -242,6 +248,438 @@ func (suite *ResourceSuite) TestResolvedDocumentURLNotCleaned() {
 	suite.Equal(path.Ext(content.Downloaded.DestPath), ".pdf", "File's extension should be .pdf")
 }
 
+func (suite *ResourceSuite) TestHarvestCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	harvested := suite.ch.HarvestResourcesContext(ctx, "Test a good URL https://t.co/csWpQq5mbn harvested after cancellation")
+	isCancelled, cancelReason := harvested.IsCancelled()
+	suite.True(isCancelled, "Harvesting should have been cancelled")
+	suite.Equal(cancelReason, context.Canceled.Error())
+	suite.Equal(len(harvested.Resources), 0, "No resources should have been completed")
+}
+
+func (suite *ResourceSuite) TestConcurrentHarvestPreservesOrder() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><head><title>%s</title></head></html>", r.URL.Path)
+	}))
+	defer server.Close()
+
+	ch := MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	ch.SetMaxWorkers(4)
+	var content strings.Builder
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(&content, "link %s/%d and ", server.URL, i)
+	}
+	content.WriteString(server.URL + "/0 again")
+
+	harvested := ch.HarvestResources(content.String())
+	suite.Equal(len(harvested.Resources), 8, "Duplicate URLs should only be harvested once")
+	for i, hr := range harvested.Resources {
+		suite.Equal(hr.OriginalURLText(), fmt.Sprintf("%s/%d", server.URL, i), "Resources should be in discovery order")
+		_, isDestValid := hr.IsValid()
+		suite.True(isDestValid, "URL should have valid destination")
+	}
+}
+
+func (suite *ResourceSuite) TestIgnoredDestinationClosed() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><title>Ignored</title></head></html>")
+	}))
+	defer server.Close()
+
+	var body *closeRecorder
+	ch := MakeContentHarvester(suite.logger, ignoreURLsRegExList{regexp.MustCompile(`/ignored$`)}, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(fetcherFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := server.Client().Do(req)
+		if err == nil {
+			body = &closeRecorder{ReadCloser: resp.Body}
+			resp.Body = body
+		}
+		return resp, err
+	}))
+	hr := ch.HarvestResources("Test " + server.URL + "/ignored in a mock tweet").Resources[0]
+	isIgnored, _ := hr.IsIgnored()
+	suite.True(isIgnored)
+	suite.True(body.closed, "The ignored destination's body should be closed so its connection is released")
+}
+
+func (suite *ResourceSuite) TestRedirectChainRecorded() {
+	mux := http.NewServeMux()
+	mux.Handle("/short", http.RedirectHandler("/wrapper", http.StatusMovedPermanently))
+	mux.Handle("/wrapper", http.RedirectHandler("/article", http.StatusFound))
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><title>Article</title></head></html>")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ch := MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	harvested := ch.HarvestResources("Test a shortened URL " + server.URL + "/short in a mock tweet")
+	suite.Equal(len(harvested.Resources), 1)
+	hr := harvested.Resources[0]
+	_, resolvedURL, _ := hr.GetURLs()
+	suite.Equal(resolvedURL.String(), server.URL+"/article")
+
+	chain := hr.RedirectChain()
+	suite.Equal(len(chain), 2, "Both HTTP redirects should have been recorded")
+	suite.Equal(chain[0].URL.String(), server.URL+"/short")
+	suite.Equal(chain[0].StatusCode, http.StatusMovedPermanently)
+	suite.Equal(chain[0].Location, "/wrapper")
+	suite.Equal(chain[0].Type, HTTPRedirect)
+	suite.Equal(chain[1].URL.String(), server.URL+"/wrapper")
+	suite.Equal(chain[1].StatusCode, http.StatusFound)
+	suite.Equal(chain[1].Method, http.MethodGet)
+}
+
+func (suite *ResourceSuite) TestHTMLRedirectChainFollowed() {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		next := map[string]string{"/shortener": "/interstitial-1", "/interstitial-1": "/interstitial-2", "/interstitial-2": "/article", "/loop-a": "/loop-b", "/loop-b": "/loop-a"}[r.URL.Path]
+		if next == "" {
+			fmt.Fprint(w, "<html><head><title>Article</title></head></html>")
+			return
+		}
+		fmt.Fprintf(w, "<html><head><meta http-equiv='refresh' content='0;url=%s%s'></head></html>", server.URL, next)
+	}))
+	defer server.Close()
+
+	ch := MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, true)
+	ch.SetFetcher(server.Client())
+	hr := ch.HarvestResources("Test " + server.URL + "/shortener in a mock tweet").Resources[0]
+	_, resolvedURL, _ := hr.GetURLs()
+	suite.Equal(resolvedURL.String(), server.URL+"/article", "All HTML redirects should have been followed")
+	suite.Equal(len(hr.RedirectChain()), 3)
+	suite.Equal(hr.RedirectChain()[0].Type, HTMLMetaRefreshRedirect)
+	suite.Equal(hr.ReferredByResource().ReferredByResource().ReferredByResource().OriginalURLText(), server.URL+"/shortener")
+	isStopped, _ := hr.IsRedirectStopped()
+	suite.False(isStopped, "Redirects should have reached the final destination")
+
+	hr = ch.HarvestResources("Test " + server.URL + "/loop-a in a mock tweet").Resources[0]
+	_, resolvedURL, _ = hr.GetURLs()
+	suite.Equal(resolvedURL.String(), server.URL+"/loop-b")
+	isStopped, stopReason := hr.IsRedirectStopped()
+	suite.True(isStopped, "Redirect loop should have been detected")
+	suite.Equal(stopReason, fmt.Sprintf("HTML redirect loop detected at '%s/loop-a'", server.URL))
+
+	ch.SetMaxHTMLRedirects(2)
+	hr = ch.HarvestResources("Test " + server.URL + "/shortener in a mock tweet").Resources[0]
+	_, resolvedURL, _ = hr.GetURLs()
+	suite.Equal(resolvedURL.String(), server.URL+"/interstitial-2")
+	isStopped, stopReason = hr.IsRedirectStopped()
+	suite.True(isStopped, "Redirects should have stopped at the hop limit")
+	suite.Equal(stopReason, "Stopped after 2 HTML redirects")
+}
+
+func (suite *ResourceSuite) TestClientSideRedirectTypes() {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("Refresh", "0;url="+server.URL+"/article")
+			fmt.Fprint(w, "<html><body>Redirecting...</body></html>")
+		case "/script":
+			fmt.Fprintf(w, "<html><body><script>if (!window.location.origin) { window.location.origin = window.location.protocol; }\nwindow.location.href = '%s/article';</script></body></html>", server.URL)
+		case "/replace":
+			fmt.Fprintf(w, "<html><body><script>location.replace(\"%s/article\")</script></body></html>", server.URL)
+		default:
+			fmt.Fprint(w, "<html><head><title>Article</title></head></html>")
+		}
+	}))
+	defer server.Close()
+
+	ch := MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	for path, redirectType := range map[string]RedirectType{"/header": HTTPRefreshHeaderRedirect, "/script": JavaScriptRedirect, "/replace": JavaScriptRedirect} {
+		hr := ch.HarvestResources("Test " + server.URL + path + " in a mock tweet").Resources[0]
+		isHTMLRedirect, htmlRedirectURLText := hr.IsHTMLRedirect()
+		suite.True(isHTMLRedirect, "There should have been a client-side redirect for %s", path)
+		suite.Equal(htmlRedirectURLText, server.URL+"/article")
+		suite.Equal(hr.HTMLRedirectType(), redirectType)
+	}
+
+	hr := ch.HarvestResources("Test " + server.URL + "/article in a mock tweet").Resources[0]
+	isHTMLRedirect, _ := hr.IsHTMLRedirect()
+	suite.False(isHTMLRedirect, "There should not have been a client-side redirect")
+}
+
+func (suite *ResourceSuite) TestCanonicalURLPolicy() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/amp/article":
+			fmt.Fprint(w, "<html><head><meta property='og:url' content='https://example.com/og'><link rel='canonical' href='/article'></head></html>")
+		case "/tracked":
+			fmt.Fprint(w, "<html><head><link rel='canonical' href='/article?id=7&utm_source=feed'></head></html>")
+		case "/shortened":
+			fmt.Fprint(w, "<html><head><link rel='canonical' href='https://t.co/abc'></head></html>")
+		case "/header":
+			w.Header().Set("Link", "<https://example.com/short>; rel=\"shortlink\", <https://example.com/from-header>; rel=\"canonical\"")
+			fmt.Fprint(w, "<html><head><meta property='og:url' content='https://example.com/og'></head></html>")
+		default:
+			fmt.Fprint(w, "<html><head><meta property='og:url' content='https://example.com/og'></head></html>")
+		}
+	}))
+	defer server.Close()
+
+	ch := MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	hr := ch.HarvestResources("Test " + server.URL + "/amp/article in a mock tweet").Resources[0]
+	canonicalURL, _ := hr.CanonicalURL()
+	suite.Nil(canonicalURL, "Canonical URLs are ignored by default")
+
+	ch.SetCanonicalURLPolicy(RecordCanonicalURL)
+	hr = ch.HarvestResources("Test " + server.URL + "/amp/article in a mock tweet").Resources[0]
+	canonicalURL, canonicalSource := hr.CanonicalURL()
+	suite.Equal(canonicalURL.String(), server.URL+"/article")
+	suite.Equal(canonicalSource, CanonicalLinkElement)
+	finalURL, resolvedURL, _ := hr.GetURLs()
+	suite.Equal(finalURL.String(), resolvedURL.String(), "Recording a canonical URL should not change finalURL")
+
+	ch.SetCanonicalURLPolicy(PreferCanonicalURL)
+	hr = ch.HarvestResources("Test " + server.URL + "/amp/article in a mock tweet").Resources[0]
+	finalURL, _, _ = hr.GetURLs()
+	suite.Equal(finalURL.String(), server.URL+"/article", "finalURL should be the canonical URL")
+	suite.Equal(hr.ResourceContent().URL.String(), finalURL.String(), "The content's URL should be the canonical URL too")
+
+	hr = ch.HarvestResources("Test " + server.URL + "/tracked in a mock tweet").Resources[0]
+	finalURL, _, _ = hr.GetURLs()
+	suite.Equal(finalURL.String(), server.URL+"/article?id=7", "The canonical URL should be cleaned")
+	suite.Equal(hr.ResourceContent().URL.String(), finalURL.String())
+
+	hr = ch.HarvestResources("Test " + server.URL + "/shortened in a mock tweet").Resources[0]
+	canonicalURL, _ = hr.CanonicalURL()
+	suite.Equal(canonicalURL.String(), "https://t.co/abc", "An ignored canonical URL should still be recorded")
+	finalURL, resolvedURL, _ = hr.GetURLs()
+	suite.Equal(finalURL.String(), resolvedURL.String(), "An ignored canonical URL should not be preferred")
+	suite.Equal(hr.ResourceContent().URL.String(), resolvedURL.String())
+
+	hr = ch.HarvestResources("Test " + server.URL + "/header in a mock tweet").Resources[0]
+	canonicalURL, canonicalSource = hr.CanonicalURL()
+	suite.Equal(canonicalURL.String(), "https://example.com/from-header")
+	suite.Equal(canonicalSource, CanonicalLinkHeader)
+
+	hr = ch.HarvestResources("Test " + server.URL + "/other in a mock tweet").Resources[0]
+	canonicalURL, canonicalSource = hr.CanonicalURL()
+	suite.Equal(canonicalURL.String(), "https://example.com/og")
+	suite.Equal(canonicalSource, CanonicalOpenGraph)
+}
+
+func (suite *ResourceSuite) TestCleanedURLVerification() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hasCampaign := len(r.URL.Query().Get("utm_campaign")) > 0
+		if r.URL.Path == "/broken" && !hasCampaign {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path == "/failing" && !hasCampaign {
+			http.Error(w, "failing", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/untitled" {
+			fmt.Fprint(w, "<html><head></head></html>")
+			return
+		}
+		title := r.URL.Path
+		if r.URL.Path == "/different" && !hasCampaign {
+			title = "Landing Page"
+		}
+		fmt.Fprintf(w, "<html><head><title>%s</title></head></html>", title)
+	}))
+	defer server.Close()
+
+	// the cleaned /refused URL is sent to a server that's no longer listening
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	closedURL, _ := url.Parse(closed.URL)
+	fetcher := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/refused" && len(req.URL.Query().Get("utm_campaign")) == 0 {
+			req.URL.Host = closedURL.Host
+		}
+		return server.Client().Do(req)
+	})
+
+	ch := MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	ch.SetCleanedURLVerification(VerifyCleanedURLStatus)
+	hr := ch.HarvestResources("Test " + server.URL + "/works?utm_campaign=test in a mock tweet").Resources[0]
+	isCleaned, cleanedURL := hr.IsCleaned()
+	suite.True(isCleaned, "URL should be 'cleaned'")
+	finalURL, _, _ := hr.GetURLs()
+	suite.Equal(finalURL.String(), cleanedURL.String(), "finalURL should be same as cleanedURL")
+
+	hr = ch.HarvestResources("Test " + server.URL + "/broken?utm_campaign=test in a mock tweet").Resources[0]
+	isCleaned, _ = hr.IsCleaned()
+	suite.False(isCleaned, "Cleaning should have been reverted")
+	isReverted, revertReason := hr.IsCleaningReverted()
+	suite.True(isReverted, "Cleaning should have been reverted")
+	suite.Equal(revertReason, "Cleaned URL returned HTTP Status Code 404 instead of 200")
+	finalURL, resolvedURL, _ := hr.GetURLs()
+	suite.Equal(finalURL.String(), resolvedURL.String(), "finalURL should be same as resolvedURL")
+
+	hr = ch.HarvestResources("Test " + server.URL + "/different?utm_campaign=test in a mock tweet").Resources[0]
+	isCleaned, _ = hr.IsCleaned()
+	suite.True(isCleaned, "Status verification doesn't compare content")
+
+	ch.SetCleanedURLVerification(VerifyCleanedURLContent)
+	hr = ch.HarvestResources("Test " + server.URL + "/different?utm_campaign=test in a mock tweet").Resources[0]
+	isReverted, revertReason = hr.IsCleaningReverted()
+	suite.True(isReverted, "Cleaning should have been reverted")
+	suite.Equal(revertReason, "Cleaned URL content differs from uncleaned content")
+
+	ch = MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(fetcher)
+	ch.SetCleanedURLVerification(VerifyCleanedURLContent)
+	hr = ch.HarvestResources("Test " + server.URL + "/failing?utm_campaign=test in a mock tweet").Resources[0]
+	isReverted, revertReason = hr.IsCleaningReverted()
+	suite.True(isReverted, "A cleaned URL that fails should be reverted")
+	suite.Equal(revertReason, "Cleaned URL returned HTTP Status Code 500 instead of 200")
+
+	hr = ch.HarvestResources("Test " + server.URL + "/refused?utm_campaign=test in a mock tweet").Resources[0]
+	isReverted, revertReason = hr.IsCleaningReverted()
+	suite.True(isReverted, "A cleaned URL that can't be retrieved should be reverted")
+	suite.Contains(revertReason, "Cleaned URL could not be retrieved")
+
+	hr = ch.HarvestResources("Test " + server.URL + "/untitled?utm_campaign=test in a mock tweet").Resources[0]
+	isReverted, revertReason = hr.IsCleaningReverted()
+	suite.True(isReverted, "Content without a fingerprint can't be verified")
+	suite.Equal(revertReason, "Uncleaned content could not be fingerprinted")
+}
+
+func (suite *ResourceSuite) TestHTMLRetainedForKeys() {
+	page := "<html><head><meta property='og:title' content='Retained Title'></head><body>" + strings.Repeat("content ", 100) + "</body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}))
+
+	ch := MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	hr := ch.HarvestResources("Test " + server.URL + "/article in a mock tweet").Resources[0]
+	content := hr.ResourceContent()
+	suite.Equal(string(content.HTML), page, "The entire HTML should have been retained")
+	suite.False(content.HTMLTruncated, "The HTML should not have been truncated")
+
+	// keys must come from the retained HTML, not another request
+	server.Close()
+	keys := CreateHarvestedResourceKeys(hr, func(random uint32, try int) bool {
+		return false
+	})
+	suite.True(keys.IsValid(), "Keys should be parsed from the retained HTML")
+
+	server = httptest.NewServer(server.Config.Handler)
+	defer server.Close()
+	ch = MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	ch.SetMaxHTMLSize(64)
+	content = ch.HarvestResources("Test " + server.URL + "/article in a mock tweet").Resources[0].ResourceContent()
+	suite.Equal(len(content.HTML), 64, "The HTML should have been limited")
+	suite.True(content.HTMLTruncated, "The HTML should have been truncated")
+	suite.True(content.IsValid(), "Truncated HTML is still valid")
+}
+
+func (suite *ResourceSuite) TestHarvestHTMLSource() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><head><title>%s</title></head></html>", r.URL.Path)
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL + "/posts/")
+	ch := MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	content := `<p>Read <a href="first">the first post</a>, <a href="/second">the second</a> and <a href="first">the first again</a>.</p>`
+	hrs := ch.HarvestResourcesFromSource(context.Background(), &ContentSource{Content: content, Format: HTMLContent, BaseURL: baseURL})
+	suite.Equal(len(hrs.Resources), 2, "Duplicate hrefs should be harvested once")
+	occurrences := hrs.Resources[0].Occurrences()
+	suite.Equal(len(occurrences), 2, "Every occurrence of a duplicate href should be recorded")
+	suite.Equal(occurrences[1].AnchorText, "the first again")
+	suite.Equal(content[occurrences[1].Offset:occurrences[1].Offset+len("first")], "first")
+	suite.Equal(occurrences[1].Line, 1)
+	suite.Equal(hrs.Resources[0].OriginalURLText(), server.URL+"/posts/first", "Relative hrefs should be resolved against the base URL")
+	suite.Equal(hrs.Resources[0].DiscoveredURL().Text, "first")
+	suite.Equal(hrs.Resources[0].AnchorText(), "the first post")
+	suite.Equal(hrs.Resources[1].OriginalURLText(), server.URL+"/second")
+	suite.Equal(hrs.Resources[1].AnchorText(), "the second")
+	isURLValid, isDestValid := hrs.Resources[1].IsValid()
+	suite.True(isURLValid && isDestValid, "Resolved URLs should be harvested")
+}
+
+func (suite *ResourceSuite) TestProvenanceSerialized() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><head><title>Provenance</title></head></html>")
+	}))
+	defer server.Close()
+
+	baseURL, _ := url.Parse(server.URL)
+	tweetURL, _ := url.Parse("https://twitter.com/example/status/1001")
+	provenance := &Provenance{
+		Kind:      TweetProvenance,
+		SourceID:  "1001",
+		Author:    "example",
+		Timestamp: time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC),
+		SourceURL: tweetURL,
+	}
+	ch := MakeContentHarvester(suite.logger, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(server.Client())
+	hrs := ch.HarvestResourcesFromSource(context.Background(), &ContentSource{
+		Content:    `<p>Look <a href="/page">here</a></p>`,
+		Format:     HTMLContent,
+		BaseURL:    baseURL,
+		Provenance: provenance,
+	})
+	suite.Equal(hrs.Provenance.SourceID, "1001")
+	suite.Equal(len(hrs.Resources), 1)
+	suite.Equal(hrs.Resources[0].Provenance().Author, "example", "Each resource should carry the source's provenance")
+	suite.Equal(hrs.Resources[0].Provenance().EmbedStyle, HTMLAnchorEmbed, "Each resource's embed style is its first occurrence's")
+	suite.Empty(provenance.EmbedStyle, "The caller's provenance should not be changed")
+
+	suite.NoError(hrs.Serialize(suite.serializer))
+	serialized := suite.markdown[server.URL+"/page"].String()
+	suite.Contains(serialized, "provSource: tweet\nprovSourceID: \"1001\"\nprovAuthor: \"example\"\nprovTimestamp: 2018-06-01 12:00:00 +0000 UTC\nprovSourceURL: https://twitter.com/example/status/1001\nprovEmbedStyle: HTML <a>\n")
+
+	hrs = ch.HarvestResourcesFromSource(context.Background(), &ContentSource{
+		Content:    `<p>Look <a href="/page">here</a></p>`,
+		Format:     HTMLContent,
+		BaseURL:    baseURL,
+		Provenance: &Provenance{Kind: EmailProvenance, SourceID: "#42: [draft]", Author: `"Jane: Writer" <jane@example.com>`, Channel: "#team: web"},
+	})
+	serializer := suite.serializer
+	serializer.GetTemplateParams = nil
+	suite.markdown[server.URL+"/page"] = new(strings.Builder)
+	suite.NoError(hrs.Serialize(serializer))
+	serialized = suite.markdown[server.URL+"/page"].String()
+	suite.Contains(serialized, "provSource: email\n", ".Params.ProvenanceType should default to the provenance's kind")
+	suite.Contains(serialized, "provSourceID: \"#42: [draft]\"\nprovAuthor: \"\\\"Jane: Writer\\\" <jane@example.com>\"\n", "Values should be quoted so the front matter is valid YAML")
+	suite.Contains(serialized, "provChannel: \"#team: web\"\n")
+}
+
+// fetcherFunc lets a function be used as a Fetcher
+type fetcherFunc func(req *http.Request) (*http.Response, error)
+
+func (f fetcherFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// closeRecorder records whether a response body was closed
+type closeRecorder struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.ReadCloser.Close()
+}
+
 func TestSuite(t *testing.T) {
 	suite.Run(t, new(ResourceSuite))
 }
@@ -0,0 +1,150 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryInitialDelay is the delay before the first retry when a RetryPolicy doesn't set one
+const DefaultRetryInitialDelay = time.Second
+
+// DefaultRetryMaxDelay is the longest delay between attempts when a RetryPolicy doesn't set one
+const DefaultRetryMaxDelay = 30 * time.Second
+
+// RetryPolicy decides how transient failures are retried: connection errors, timeouts and HTTP
+// status codes the HTTPStatusPolicy classifies as retryable (e.g. 429 Too Many Requests and 503
+// Service Unavailable). The zero value doesn't retry, which is the default.
+//
+// The delay before each retry doubles, starting at InitialDelay, up to MaxDelay; it's then
+// jittered to between half and all of that so many resources throttled at once don't retry
+// together. A Retry-After header is waited for if it's longer, but never for more than MaxDelay.
+type RetryPolicy struct {
+	MaxRetries   int           // how many times a URL is retried after the first attempt
+	InitialDelay time.Duration // DefaultRetryInitialDelay if 0
+	MaxDelay     time.Duration // DefaultRetryMaxDelay if 0
+}
+
+// FetchAttempt is a single request made for a resource's URL; there's more than one when
+// transient failures were retried
+type FetchAttempt struct {
+	Timestamp  time.Time
+	StatusCode int           // 0 if there was no response
+	Err        error         // why the request failed, if it did
+	RetryAfter time.Duration // how long the response's Retry-After header asked to wait, if it did
+	Delay      time.Duration // how long the harvester waited before the next attempt, 0 for the last one
+}
+
+func (p RetryPolicy) initialDelay() time.Duration {
+	if p.InitialDelay <= 0 {
+		return DefaultRetryInitialDelay
+	}
+	return p.InitialDelay
+}
+
+func (p RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay <= 0 {
+		return DefaultRetryMaxDelay
+	}
+	return p.MaxDelay
+}
+
+// backoff returns the jittered delay before the given retry, counting from 0
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay, maxDelay := p.initialDelay(), p.maxDelay()
+	for i := 0; i < retry && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay/2 + time.Duration(mathrand.Float64()*float64(delay/2))
+}
+
+// fetchWithRetries fetches the URL like fetch, retrying transient failures according to the
+// harvester's RetryPolicy, and returns the last response or error with every attempt made
+func (h *ContentHarvester) fetchWithRetries(ctx context.Context, urlText string) (*http.Response, []*FetchAttempt, error) {
+	var attempts []*FetchAttempt
+	for retry := 0; ; retry++ {
+		attempt := &FetchAttempt{Timestamp: time.Now()}
+		attempts = append(attempts, attempt)
+		resp, err := h.fetch(ctx, urlText)
+		attempt.Err = err
+		if resp != nil {
+			attempt.StatusCode = resp.StatusCode
+		}
+
+		var isRetryable bool
+		if err != nil {
+			// a response with an error is a redirect the fetcher refused to follow
+			isRetryable = resp == nil && isRetryableFetchError(err)
+		} else {
+			isRetryable = h.httpStatusPolicy.Classify(resp.StatusCode) == RetryableHTTPStatus
+			attempt.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		if !isRetryable || retry >= h.retryPolicy.MaxRetries || ctx.Err() != nil {
+			return resp, attempts, err
+		}
+
+		attempt.Delay = h.retryPolicy.backoff(retry)
+		if attempt.RetryAfter > attempt.Delay {
+			attempt.Delay = attempt.RetryAfter
+			if maxDelay := h.retryPolicy.maxDelay(); attempt.Delay > maxDelay {
+				attempt.Delay = maxDelay
+			}
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(attempt.Delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, attempts, ctx.Err()
+		}
+	}
+}
+
+// isRetryableFetchError indicates whether a request failed in a way that may not happen again: a
+// timeout, a reset or aborted connection, a DNS server failure or a connection dropped without a
+// response. Permanent errors such as refused connections, unreachable networks, unknown hosts and TLS
+// errors aren't retried.
+func isRetryableFetchError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// parseRetryAfter returns how long a Retry-After header, which is either a number of seconds or
+// an HTTP date, asks to wait; it's 0 if there's no header or it can't be parsed
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,230 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RetrySuite struct {
+	suite.Suite
+	server   *httptest.Server
+	mutex    sync.Mutex
+	requests map[string]int
+}
+
+func (suite *RetrySuite) SetupSuite() {
+	suite.requests = make(map[string]int)
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.mutex.Lock()
+		suite.requests[r.URL.Path]++
+		count := suite.requests[r.URL.Path]
+		suite.mutex.Unlock()
+
+		switch {
+		case r.URL.Path == "/flaky" && count <= 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case r.URL.Path == "/throttled" && count == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		case r.URL.Path == "/throttled-long":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		case r.URL.Path == "/down":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		case r.URL.Path == "/dropped" && count == 1:
+			// close the connection without a response
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><head><title>%s</title></head></html>", r.URL.Path)
+	}))
+}
+
+func (suite *RetrySuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+func (suite *RetrySuite) SetupTest() {
+	suite.mutex.Lock()
+	suite.requests = make(map[string]int)
+	suite.mutex.Unlock()
+}
+
+// harvest harvests a single path on the test server
+func (suite *RetrySuite) harvest(policy RetryPolicy, path string) *HarvestedResource {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	ch.SetRetryPolicy(policy)
+	hrs := ch.HarvestResourcesFromSource(context.Background(), &ContentSource{Content: suite.server.URL + path})
+	suite.Equal(len(hrs.Resources), 1)
+	return hrs.Resources[0]
+}
+
+func (suite *RetrySuite) TestNoRetriesByDefault() {
+	hr := suite.harvest(RetryPolicy{}, "/flaky")
+	isRetryable, _ := hr.IsRetryable()
+	suite.True(isRetryable)
+	suite.Equal(len(hr.FetchAttempts()), 1)
+	suite.Equal(hr.FetchAttempts()[0].StatusCode, http.StatusServiceUnavailable)
+	suite.Zero(hr.FetchAttempts()[0].Delay)
+}
+
+func (suite *RetrySuite) TestRetryUntilValid() {
+	hr := suite.harvest(RetryPolicy{MaxRetries: 3, InitialDelay: time.Millisecond}, "/flaky")
+	_, isDestValid := hr.IsValid()
+	suite.True(isDestValid, "The third attempt should succeed")
+
+	attempts := hr.FetchAttempts()
+	suite.Equal(len(attempts), 3)
+	suite.Equal(attempts[0].StatusCode, http.StatusServiceUnavailable)
+	suite.Equal(attempts[1].StatusCode, http.StatusServiceUnavailable)
+	suite.Equal(attempts[2].StatusCode, http.StatusOK)
+	suite.True(attempts[0].Delay >= time.Millisecond/2 && attempts[0].Delay <= time.Millisecond, "The first delay should be jittered")
+	suite.True(attempts[1].Delay >= time.Millisecond && attempts[1].Delay <= 2*time.Millisecond, "The delay should double")
+	suite.Zero(attempts[2].Delay)
+}
+
+func (suite *RetrySuite) TestRetriesExhausted() {
+	hr := suite.harvest(RetryPolicy{MaxRetries: 2, InitialDelay: time.Millisecond}, "/down")
+	isRetryable, reason := hr.IsRetryable()
+	suite.True(isRetryable)
+	suite.Equal(reason, "Temporarily unavailable, HTTP Status Code 502")
+	suite.Equal(len(hr.FetchAttempts()), 3, "There should be the first attempt and two retries")
+}
+
+func (suite *RetrySuite) TestRetryAfter() {
+	hr := suite.harvest(RetryPolicy{MaxRetries: 1, InitialDelay: time.Millisecond}, "/throttled")
+	_, isDestValid := hr.IsValid()
+	suite.True(isDestValid)
+	attempts := hr.FetchAttempts()
+	suite.Equal(len(attempts), 2)
+	suite.Equal(attempts[0].RetryAfter, time.Second)
+	suite.Equal(attempts[0].Delay, time.Second, "Retry-After should be honoured when it's longer than the backoff")
+	suite.True(attempts[1].Timestamp.Sub(attempts[0].Timestamp) >= time.Second)
+
+	hr = suite.harvest(RetryPolicy{MaxRetries: 1, InitialDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}, "/throttled-long")
+	attempts = hr.FetchAttempts()
+	suite.Equal(len(attempts), 2, "Retry-After longer than the maximum delay should still be retried")
+	suite.Equal(attempts[0].RetryAfter, time.Hour)
+	suite.Equal(attempts[0].Delay, 50*time.Millisecond, "The wait should be limited to the maximum delay")
+	isRetryable, _ := hr.IsRetryable()
+	suite.True(isRetryable, "The retry should have been throttled too")
+
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	suite.Equal(parseRetryAfter("Fri, 01 Jun 2018 12:00:30 GMT", now), 30*time.Second)
+	suite.Zero(parseRetryAfter("soon", now))
+}
+
+func (suite *RetrySuite) TestConnectionErrors() {
+	// http.Transport itself retries requests dropped on reused connections, so each request gets a new one
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(client)
+	ch.SetRetryPolicy(RetryPolicy{MaxRetries: 1, InitialDelay: time.Millisecond})
+	hrs := ch.HarvestResourcesFromSource(context.Background(), &ContentSource{Content: suite.server.URL + "/dropped"})
+	hr := hrs.Resources[0]
+	isURLValid, isDestValid := hr.IsValid()
+	suite.True(isURLValid && isDestValid, "A dropped connection should be retried")
+	suite.Equal(len(hr.FetchAttempts()), 2)
+	suite.Error(hr.FetchAttempts()[0].Err)
+	suite.Zero(hr.FetchAttempts()[0].StatusCode)
+
+	malformed := &DiscoveredURL{Text: "http://%zz", URL: "http://%zz", Offset: -1}
+	hrs = ch.HarvestResourcesFromSource(context.Background(), &ContentSource{Links: []*DiscoveredURL{malformed}})
+	suite.Equal(len(hrs.Resources[0].FetchAttempts()), 1, "Malformed URLs should not be retried")
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	hrs = ch.HarvestResourcesFromSource(context.Background(), &ContentSource{Content: closed.URL + "/refused"})
+	attempts := hrs.Resources[0].FetchAttempts()
+	suite.Equal(len(attempts), 1, "Refused connections should not be retried")
+	suite.Error(attempts[0].Err)
+	suite.False(isRetryableFetchError(attempts[0].Err))
+}
+
+func (suite *RetrySuite) TestBackoff() {
+	for _, test := range []struct {
+		policy RetryPolicy
+		retry  int
+		delay  time.Duration // the delay before jitter, which is between half and all of it
+	}{
+		{RetryPolicy{}, 0, DefaultRetryInitialDelay},
+		{RetryPolicy{}, 3, 8 * DefaultRetryInitialDelay},
+		{RetryPolicy{}, 10, DefaultRetryMaxDelay},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond}, 0, 100 * time.Millisecond},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond}, 1, 200 * time.Millisecond},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond}, 2, 400 * time.Millisecond},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}, 2, 300 * time.Millisecond},
+		{RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}, 50, 300 * time.Millisecond},
+		{RetryPolicy{InitialDelay: time.Minute, MaxDelay: time.Second}, 0, time.Second},
+	} {
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			delay := test.policy.backoff(test.retry)
+			suite.True(delay >= test.delay/2 && delay <= test.delay, "Retry %d of %+v should wait between %v and %v, not %v",
+				test.retry, test.policy, test.delay/2, test.delay, delay)
+			seen[delay] = true
+		}
+		suite.True(len(seen) > 1, "Retry %d of %+v should be jittered", test.retry, test.policy)
+	}
+}
+
+func (suite *RetrySuite) TestRetryableFetchErrors() {
+	syscallErr := func(errno syscall.Errno) error {
+		return &url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", errno)}}
+	}
+	for _, test := range []struct {
+		err         error
+		isRetryable bool
+	}{
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, true},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{syscallErr(syscall.ECONNRESET), true},
+		{syscallErr(syscall.ECONNABORTED), true},
+		{syscallErr(syscall.ECONNREFUSED), false},
+		{syscallErr(syscall.ENETUNREACH), false},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: io.EOF}, true},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("x509: certificate signed by unknown authority"), false},
+	} {
+		suite.Equal(isRetryableFetchError(test.err), test.isRetryable, "%v", test.err)
+	}
+}
+
+func (suite *RetrySuite) TestCancelledWhileWaiting() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	ch.SetRetryPolicy(RetryPolicy{MaxRetries: 5, InitialDelay: time.Minute, MaxDelay: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	hrs := ch.HarvestResourcesFromSource(ctx, &ContentSource{Content: suite.server.URL + "/down"})
+	suite.True(time.Since(started) < 10*time.Second, "Cancellation should interrupt the backoff")
+	isCancelled, _ := hrs.IsCancelled()
+	suite.True(isCancelled)
+	suite.Empty(hrs.Resources)
+}
+
+func TestRetrySuite(t *testing.T) {
+	suite.Run(t, new(RetrySuite))
+}
@@ -0,0 +1,119 @@
+package harvester
+
+import (
+	"html"
+	"sort"
+	"strings"
+)
+
+// RewriteLinkForm decides how URLs in plain text content are written when the content is rewritten
+type RewriteLinkForm int
+
+const (
+	// RewriteAsPlainText replaces each URL with its finalURL
+	RewriteAsPlainText RewriteLinkForm = iota
+
+	// RewriteAsMarkdown replaces each URL with a Markdown link, [anchor text](finalURL)
+	RewriteAsMarkdown
+
+	// RewriteAsHTML replaces each URL with an <a href="finalURL"> link and escapes the rest of the text
+	RewriteAsHTML
+)
+
+// rewriteLinkForms are the names of each RewriteLinkForm used in templates
+var rewriteLinkForms = map[string]RewriteLinkForm{
+	"plain":    RewriteAsPlainText,
+	"markdown": RewriteAsMarkdown,
+	"html":     RewriteAsHTML,
+}
+
+// RewritePolicy decides how HarvestedResources.RewriteContent rewrites the harvested content
+type RewritePolicy struct {
+	// Form is how each valid URL is written in plain text content; in HTML and Markdown content
+	// URLs are always replaced where they are (e.g. inside href="...") so the markup stays intact
+	Form RewriteLinkForm
+
+	// StripIgnored removes URLs that matched an ignore rule from plain text content; in HTML and
+	// Markdown content they're left in place since removing them would break the markup
+	StripIgnored bool
+}
+
+// rewriteEdit replaces one occurrence of a URL in the content
+type rewriteEdit struct {
+	start, end  int
+	replacement string
+}
+
+// RewriteContent returns the harvested content with each discovered URL replaced by its finalURL
+// (e.g. shortened t.co links are replaced by their destinations), using the offsets recorded for
+// every occurrence. Invalid URLs are left untouched, as are ignored URLs unless policy strips them.
+func (r *HarvestedResources) RewriteContent(policy RewritePolicy) string {
+	var edits []rewriteEdit
+	for _, hr := range r.Resources {
+		isURLValid, isDestValid := hr.IsValid()
+		isIgnored, _ := hr.IsIgnored()
+		finalURL, _, _ := hr.GetURLs()
+		for _, occurrence := range hr.Occurrences() {
+			start, end := occurrence.Offset, occurrence.Offset+len(occurrence.Text)
+			if start < 0 || end > len(r.Content) || r.Content[start:end] != occurrence.Text {
+				// the occurrence wasn't located (e.g. an escaped HTML attribute)
+				continue
+			}
+			// failed harvests (unparseable URLs, unreachable destinations) are flagged as ignored
+			// too, so validity is checked first to leave them alone
+			switch {
+			case !isURLValid || !isDestValid:
+				// left untouched
+			case isIgnored:
+				if policy.StripIgnored && r.format == PlainTextContent {
+					edits = append(edits, rewriteEdit{start, end, ""})
+				}
+			case finalURL != nil:
+				edits = append(edits, rewriteEdit{start, end, r.rewriteURL(policy, finalURL.String(), occurrence)})
+			}
+		}
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	// text between URLs is only changed when plain text is turned into HTML
+	text := func(s string) string { return s }
+	if policy.Form == RewriteAsHTML && r.format == PlainTextContent {
+		text = html.EscapeString
+	}
+
+	var result strings.Builder
+	last := 0
+	for _, edit := range edits {
+		if edit.start < last {
+			// overlapping occurrences can only come from a custom Discoverer; keep the first
+			continue
+		}
+		result.WriteString(text(r.Content[last:edit.start]))
+		result.WriteString(edit.replacement)
+		last = edit.end
+	}
+	result.WriteString(text(r.Content[last:]))
+	return result.String()
+}
+
+// rewriteURL returns what replaces a single occurrence of a URL
+func (r *HarvestedResources) rewriteURL(policy RewritePolicy, finalURL string, occurrence *DiscoveredURL) string {
+	switch r.format {
+	case HTMLContent:
+		return html.EscapeString(finalURL)
+	case MarkdownContent:
+		return (&markdownConverter{}).resolve(finalURL)
+	}
+
+	label := occurrence.AnchorText
+	if len(label) == 0 {
+		label = finalURL
+	}
+	switch policy.Form {
+	case RewriteAsMarkdown:
+		return "[" + markdownEscaper.Replace(label) + "](" + (&markdownConverter{}).resolve(finalURL) + ")"
+	case RewriteAsHTML:
+		return `<a href="` + html.EscapeString(finalURL) + `">` + html.EscapeString(label) + "</a>"
+	}
+	return finalURL
+}
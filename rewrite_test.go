@@ -0,0 +1,122 @@
+package harvester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RewriteSuite struct {
+	suite.Suite
+	ch             *ContentHarvester
+	unreachableURL string
+}
+
+func (suite *RewriteSuite) SetupSuite() {
+	suite.ch = MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	server := httptest.NewServer(http.NotFoundHandler())
+	suite.unreachableURL = server.URL + "/gone"
+	server.Close()
+}
+
+// harvested discovers the URLs in content and marks them as harvested; destinations maps each discovered
+// URL to its finalURL or to "ignored", without fetching anything, or to "unreachable" or "unparseable",
+// in which case a URL that fails that way is really harvested
+func (suite *RewriteSuite) harvested(content string, format ContentFormat, destinations map[string]string) *HarvestedResources {
+	result := &HarvestedResources{Content: content, format: format}
+	byURL := make(map[string]*HarvestedResource)
+	for _, discovered := range MakeURLDiscoverer(URLDiscoveryConfig{TrimPunctuation: true}).DiscoverURLs(&ContentSource{Content: content, Format: format}) {
+		if hr, found := byURL[discovered.URL]; found {
+			hr.occurrences = append(hr.occurrences, discovered)
+			continue
+		}
+		hr := &HarvestedResource{origURLtext: discovered.URL, occurrences: []*DiscoveredURL{discovered}}
+		switch destination := destinations[discovered.URL]; destination {
+		case "unreachable":
+			hr = harvestResource(context.Background(), suite.ch, suite.unreachableURL)
+			hr.occurrences = []*DiscoveredURL{discovered}
+		case "unparseable":
+			hr = harvestResource(context.Background(), suite.ch, "http://%zz")
+			hr.occurrences = []*DiscoveredURL{discovered}
+		case "ignored":
+			hr.isURLValid, hr.isDestValid, hr.isURLIgnored = true, true, true
+		default:
+			hr.isURLValid, hr.isDestValid = true, true
+			hr.finalURL, _ = url.Parse(destination)
+		}
+		byURL[discovered.URL] = hr
+		result.Resources = append(result.Resources, hr)
+	}
+	return result
+}
+
+func (suite *RewriteSuite) TestPlainText() {
+	content := "New post https://t.co/abc123 & https://t.co/broken (via https://t.co/abc123, https://t.co/tweet)"
+	hrs := suite.harvested(content, PlainTextContent, map[string]string{
+		"https://t.co/abc123": "https://example.com/post?id=1&ref=home",
+		"https://t.co/broken": "unreachable",
+		"https://t.co/tweet":  "ignored",
+	})
+
+	suite.Equal("New post https://example.com/post?id=1&ref=home & https://t.co/broken (via https://example.com/post?id=1&ref=home, https://t.co/tweet)",
+		hrs.RewriteContent(RewritePolicy{Form: RewriteAsPlainText}), "Every occurrence should be replaced, invalid and ignored URLs left alone")
+	suite.Equal("New post https://example.com/post?id=1&ref=home & https://t.co/broken (via https://example.com/post?id=1&ref=home, )",
+		hrs.RewriteContent(RewritePolicy{Form: RewriteAsPlainText, StripIgnored: true}), "Ignored URLs should be stripped, invalid ones left alone")
+	suite.Equal("New post [https://example.com/post?id=1&ref=home](https://example.com/post?id=1&ref=home) & https://t.co/broken (via [https://example.com/post?id=1&ref=home](https://example.com/post?id=1&ref=home), https://t.co/tweet)",
+		hrs.RewriteContent(RewritePolicy{Form: RewriteAsMarkdown}))
+	suite.Equal(`New post <a href="https://example.com/post?id=1&amp;ref=home">https://example.com/post?id=1&amp;ref=home</a> &amp; https://t.co/broken (via <a href="https://example.com/post?id=1&amp;ref=home">https://example.com/post?id=1&amp;ref=home</a>, https://t.co/tweet)`,
+		hrs.RewriteContent(RewritePolicy{Form: RewriteAsHTML}), "The rest of the text should be escaped for HTML")
+}
+
+func (suite *RewriteSuite) TestInvalidNotStripped() {
+	content := "Broken https://t.co/broken and https://t.co/malformed links"
+	hrs := suite.harvested(content, PlainTextContent, map[string]string{
+		"https://t.co/broken":    "unreachable",
+		"https://t.co/malformed": "unparseable",
+	})
+	for _, hr := range hrs.Resources {
+		isURLValid, isDestValid := hr.IsValid()
+		suite.False(isURLValid && isDestValid, "%s should be invalid", hr.OriginalURLText())
+	}
+	suite.Equal(content, hrs.RewriteContent(RewritePolicy{Form: RewriteAsPlainText, StripIgnored: true}), "Invalid URLs should never be stripped")
+}
+
+func (suite *RewriteSuite) TestMarkup() {
+	content := `<p>Read <a href="https://t.co/abc123">this</a> &amp; <a href="https://t.co/tweet">that</a></p>`
+	hrs := suite.harvested(content, HTMLContent, map[string]string{
+		"https://t.co/abc123": "https://example.com/post?id=1&ref=home",
+		"https://t.co/tweet":  "ignored",
+	})
+	suite.Equal(`<p>Read <a href="https://example.com/post?id=1&amp;ref=home">this</a> &amp; <a href="https://t.co/tweet">that</a></p>`,
+		hrs.RewriteContent(RewritePolicy{Form: RewriteAsMarkdown, StripIgnored: true}), "HTML links should be replaced in place")
+
+	content = "Read [this](https://t.co/abc123) or https://t.co/abc123"
+	hrs = suite.harvested(content, MarkdownContent, map[string]string{
+		"https://t.co/abc123": "https://example.com/wiki/Go_(language)",
+	})
+	suite.Equal("Read [this](https://example.com/wiki/Go_%28language%29) or https://example.com/wiki/Go_%28language%29",
+		hrs.RewriteContent(RewritePolicy{Form: RewriteAsHTML}), "Markdown links should be replaced in place")
+}
+
+func (suite *RewriteSuite) TestTemplateFunc() {
+	hrs := suite.harvested("See https://t.co/abc123", PlainTextContent, map[string]string{
+		"https://t.co/abc123": "https://example.com/post",
+	})
+	t := template.Must(template.New("rewrite").Funcs(TemplateFuncs()).Parse(`{{ rewrite .Harvested "markdown" }}`))
+	var output strings.Builder
+	suite.NoError(t.Execute(&output, struct{ Harvested *HarvestedResources }{hrs}))
+	suite.Equal("See [https://example.com/post](https://example.com/post)", output.String())
+
+	t = template.Must(template.New("rewrite").Funcs(TemplateFuncs()).Parse(`{{ rewrite .Harvested "rtf" }}`))
+	suite.Error(t.Execute(&output, struct{ Harvested *HarvestedResources }{hrs}), "Unknown forms should be reported")
+}
+
+func TestRewriteSuite(t *testing.T) {
+	suite.Run(t, new(RewriteSuite))
+}
@@ -0,0 +1,264 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SlackMessage is a single message from a Slack workspace export. URLs are the links in the
+// message's text markup (<url> and <url|label>), in the order they appear, followed by the
+// URLs of its attachment unfurls.
+type SlackMessage struct {
+	Channel   string
+	ChannelID string
+	UserID    string
+	User      string
+	TS        string // Slack's identifier for the message within its channel, e.g. "1528000000.000100"
+	Timestamp time.Time
+	Text      string
+	URLs      []*DiscoveredURL
+}
+
+// slackChannelJSON is a channel in channels.json, groups.json, mpims.json or dms.json
+type slackChannelJSON struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// slackUserJSON is a user in users.json, which has a profile, or a message's user_profile, which
+// has the profile's names itself
+type slackUserJSON struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	RealName    string `json:"real_name"`
+	DisplayName string `json:"display_name"`
+	Profile     struct {
+		RealName    string `json:"real_name"`
+		DisplayName string `json:"display_name"`
+	} `json:"profile"`
+}
+
+// displayName returns the name Slack shows for the user
+func (u *slackUserJSON) displayName() string {
+	for _, name := range []string{u.Profile.DisplayName, u.DisplayName, u.Profile.RealName, u.RealName, u.Name} {
+		if len(name) > 0 {
+			return name
+		}
+	}
+	return ""
+}
+
+type slackMessageJSON struct {
+	Type        string         `json:"type"`
+	User        string         `json:"user"`
+	Username    string         `json:"username"`
+	UserProfile *slackUserJSON `json:"user_profile"`
+	Text        string         `json:"text"`
+	TS          string         `json:"ts"`
+	Attachments []struct {
+		OriginalURL string `json:"original_url"`
+		FromURL     string `json:"from_url"`
+		TitleLink   string `json:"title_link"`
+		Title       string `json:"title"`
+	} `json:"attachments"`
+}
+
+// slackChannelFiles are the files in an export that list the channels, private channels,
+// group DMs and DMs; each has a directory of messages named after it (DMs use their ID)
+var slackChannelFiles = []string{"channels.json", "groups.json", "mpims.json", "dms.json"}
+
+// ParseSlackExport reads an unzipped Slack workspace export, which has users.json, the channel lists
+// and a directory per channel with a JSON file of messages for each day. Messages are returned channel
+// by channel, in the order they were posted.
+func ParseSlackExport(dir string) ([]*SlackMessage, error) {
+	users := make(map[string]string)
+	var userList []*slackUserJSON
+	if err := readSlackExportFile(filepath.Join(dir, "users.json"), &userList); err != nil {
+		return nil, err
+	}
+	for _, user := range userList {
+		users[user.ID] = user.displayName()
+	}
+
+	channelIDs := make(map[string]string)
+	for _, fileName := range slackChannelFiles {
+		var channels []*slackChannelJSON
+		if err := readSlackExportFile(filepath.Join(dir, fileName), &channels); err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			channelIDs[channel.Name] = channel.ID
+			channelIDs[channel.ID] = channel.ID
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var result []*SlackMessage
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		days, err := filepath.Glob(filepath.Join(dir, entry.Name(), "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(days)
+		for _, day := range days {
+			var messages []*slackMessageJSON
+			if err := readSlackExportFile(day, &messages); err != nil {
+				return nil, err
+			}
+			for _, message := range messages {
+				if len(message.Type) > 0 && message.Type != "message" {
+					continue
+				}
+				result = append(result, message.parse(entry.Name(), channelIDs[entry.Name()], users))
+			}
+		}
+	}
+	return result, nil
+}
+
+// readSlackExportFile decodes one of the export's JSON files; missing files are skipped since
+// exports only have the channel lists they need
+func readSlackExportFile(fileName string, v interface{}) error {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unable to parse Slack export file %s: %v", fileName, err)
+	}
+	return nil
+}
+
+// parse converts a message, looking its author up in users
+func (m *slackMessageJSON) parse(channel string, channelID string, users map[string]string) *SlackMessage {
+	result := &SlackMessage{Channel: channel, ChannelID: channelID, UserID: m.User, TS: m.TS, Text: m.Text}
+	result.User = users[m.User]
+	if len(result.User) == 0 && m.UserProfile != nil {
+		result.User = m.UserProfile.displayName()
+	}
+	if len(result.User) == 0 {
+		result.User = m.Username
+	}
+	if len(result.User) == 0 {
+		result.User = m.User
+	}
+	result.Timestamp = parseSlackTS(m.TS)
+
+	result.URLs = discoverSlackLinks(m.Text)
+	for _, attachment := range m.Attachments {
+		unfurl := attachment.OriginalURL
+		if len(unfurl) == 0 {
+			unfurl = attachment.FromURL
+		}
+		if len(unfurl) == 0 {
+			unfurl = attachment.TitleLink
+		}
+		if urlText, ok := resolveDiscoveredURL(unfurl, nil); ok {
+			result.URLs = append(result.URLs, &DiscoveredURL{Text: unfurl, URL: urlText, AnchorText: attachment.Title, Offset: -1, EmbedStyle: ChatUnfurlEmbed})
+		}
+	}
+	return result
+}
+
+// parseSlackTS converts a message's ts, which is seconds and microseconds since the epoch, to a time
+func parseSlackTS(ts string) time.Time {
+	parts := strings.SplitN(ts, ".", 2)
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	var micros int64
+	if len(parts) == 2 {
+		micros, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+	return time.Unix(seconds, micros*int64(time.Microsecond)).UTC()
+}
+
+// discoverSlackLinks finds the links in Slack's text markup, skipping the user, channel and
+// special mentions (<@U123>, <#C123|general>, <!here>) that share its syntax. Each link's Text
+// is the URL as it appears in the text, where &, < and > are escaped as HTML entities.
+func discoverSlackLinks(text string) []*DiscoveredURL {
+	var result []*DiscoveredURL
+	for pos := 0; pos < len(text); {
+		open := strings.IndexByte(text[pos:], '<')
+		if open < 0 {
+			break
+		}
+		open += pos
+		end := strings.IndexByte(text[open:], '>')
+		if end < 0 {
+			break
+		}
+		end += open
+		pos = end + 1
+
+		target, label := text[open+1:end], ""
+		if bar := strings.IndexByte(target, '|'); bar >= 0 {
+			target, label = target[:bar], target[bar+1:]
+		}
+		if len(target) == 0 || strings.ContainsAny(target[:1], "@#!") {
+			continue
+		}
+		if urlText, ok := resolveDiscoveredURL(html.UnescapeString(target), nil); ok {
+			result = append(result, &DiscoveredURL{Text: target, URL: urlText, AnchorText: html.UnescapeString(label), Offset: open + 1, EmbedStyle: SlackLinkEmbed})
+		}
+	}
+	return result
+}
+
+// ContentSource returns the message's text for harvesting, with its provenance and with its links and
+// unfurls as its only links. Slack exports don't say which workspace they're from, so there's
+// no SourceURL.
+func (m *SlackMessage) ContentSource() *ContentSource {
+	return &ContentSource{
+		Content: m.Text,
+		Format:  PlainTextContent,
+		Provenance: &Provenance{
+			Kind:      ChatMessageProvenance,
+			SourceID:  m.TS,
+			Author:    m.User,
+			Channel:   m.Channel,
+			Timestamp: m.Timestamp,
+		},
+		Links:     m.URLs,
+		LinksOnly: true,
+	}
+}
+
+// HarvestSlackExport parses an unzipped Slack workspace export (see ParseSlackExport) and harvests each
+// message's links and unfurls, returning one HarvestedResources per message (like every adapter, messages
+// without any URLs are included so the results line up with ParseSlackExport's). Harvesting stops at the
+// first message that's cancelled.
+func (h *ContentHarvester) HarvestSlackExport(ctx context.Context, dir string) ([]*HarvestedResources, error) {
+	messages, err := ParseSlackExport(dir)
+	if err != nil {
+		return nil, err
+	}
+	var result []*HarvestedResources
+	for _, message := range messages {
+		harvested := h.HarvestResourcesFromSource(ctx, message.ContentSource())
+		result = append(result, harvested)
+		if isCancelled, _ := harvested.IsCancelled(); isCancelled {
+			break
+		}
+	}
+	return result, nil
+}
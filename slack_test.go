@@ -0,0 +1,77 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SlackSuite struct {
+	fixtureSuite
+	exportDir string
+}
+
+func (suite *SlackSuite) SetupSuite() {
+	suite.fixtureSuite.SetupSuite()
+	suite.exportDir = suite.fixtureDir("testdata/slack")
+}
+
+func (suite *SlackSuite) TestParseSlackExport() {
+	messages, err := ParseSlackExport(suite.exportDir)
+	suite.NoError(err)
+	suite.Equal(len(messages), 5, "Messages from every channel and DM should be read")
+
+	suite.Equal(messages[0].Channel, "D0DIRECT", "DMs are named by their ID")
+	suite.Equal(messages[0].ChannelID, "D0DIRECT")
+
+	message := messages[2]
+	suite.Equal(message.Channel, "general")
+	suite.Equal(message.ChannelID, "C01")
+	suite.Equal(message.UserID, "U01")
+	suite.Equal(message.User, "janep", "The user's display name should be looked up in users.json")
+	suite.True(message.Timestamp.Equal(time.Date(2018, 6, 1, 12, 0, 0, 200000, time.UTC)))
+	suite.Equal(len(message.URLs), 4, "Mentions should be skipped and unfurls added after the links")
+
+	story := message.URLs[0]
+	suite.Equal(story.Text, suite.server.URL+"/story?utm_source=slack&amp;id=7")
+	suite.Equal(story.URL, suite.server.URL+"/story?utm_source=slack&id=7", "Entities in links should be unescaped")
+	suite.Equal(story.AnchorText, "the story")
+	suite.Equal(message.Text[story.Offset:story.Offset+len(story.Text)], story.Text)
+	suite.Equal(message.URLs[1].URL, suite.server.URL+"/plain")
+	suite.Equal(message.URLs[2].EmbedStyle, ChatUnfurlEmbed)
+	suite.Equal(message.URLs[3].AnchorText, "Unfurled only")
+
+	suite.Equal(messages[3].User, "guest", "The message's user_profile should be used for unknown users")
+	suite.Equal(messages[4].User, "newsbot", "Bots should be named by their username")
+	suite.Equal(len(messages[4].URLs), 1, "mailto: links should be skipped")
+}
+
+func (suite *SlackSuite) TestHarvestSlackExport() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	harvested, err := ch.HarvestSlackExport(context.Background(), suite.exportDir)
+	suite.NoError(err)
+	suite.Equal(len(harvested), 5, "There should be one result per message")
+	suite.Empty(harvested[1].Resources, "Messages without URLs should have no resources")
+
+	hrs := harvested[2]
+	suite.Equal(hrs.Provenance.Kind, ChatMessageProvenance)
+	suite.Equal(hrs.Provenance.SourceID, "1527854400.000200")
+	suite.Equal(hrs.Provenance.Channel, "general")
+	suite.Equal(hrs.Provenance.Author, "janep")
+	suite.Equal(len(hrs.Resources), 3, "An unfurl of a link in the text should be another occurrence of it")
+
+	story := hrs.Resources[0]
+	suite.Equal(story.Provenance().EmbedStyle, SlackLinkEmbed)
+	suite.Equal(len(story.Occurrences()), 2)
+	isCleaned, cleanedURL := story.IsCleaned()
+	suite.True(isCleaned)
+	suite.Equal(cleanedURL.String(), suite.server.URL+"/story?id=7")
+	suite.Equal(hrs.Resources[2].Provenance().EmbedStyle, ChatUnfurlEmbed)
+}
+
+func TestSlackSuite(t *testing.T) {
+	suite.Run(t, new(SlackSuite))
+}
@@ -0,0 +1,81 @@
+package harvester
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HTTPStatusClass is how a destination's HTTP status code is treated
+type HTTPStatusClass int
+
+const (
+	// ValidHTTPStatus destinations are harvested: cleaned, inspected and so on
+	ValidHTTPStatus HTTPStatusClass = iota
+
+	// InvalidHTTPStatus destinations don't exist (e.g. 404 Not Found) or are broken
+	InvalidHTTPStatus
+
+	// RetryableHTTPStatus destinations are temporarily unavailable (e.g. 429 Too Many Requests or
+	// 503 Service Unavailable) so harvesting them later may work; see RetryPolicy
+	RetryableHTTPStatus
+
+	// RestrictedHTTPStatus destinations exist but require authorization or payment (e.g. 401
+	// Unauthorized or a 403 Forbidden paywall)
+	RestrictedHTTPStatus
+)
+
+// HTTPStatusPolicy classifies the HTTP status codes of destinations. Codes that aren't in the map
+// are valid if they're 2xx, retryable if they're 5xx (server errors) and invalid otherwise.
+type HTTPStatusPolicy map[int]HTTPStatusClass
+
+// DefaultHTTPStatusPolicy returns the policy used unless changed with SetHTTPStatusPolicy; it
+// can be modified before it's set, e.g. to treat 403 Forbidden as invalid. Besides 2xx being
+// valid and 5xx retryable, it has these exceptions:
+//
+//	401, 402, 403, 407, 451 and 511 are restricted (they need a login, payment and so on)
+//	408 Request Timeout and 429 Too Many Requests are retryable
+//	501, 505, 506, 508 and 510 are invalid since the server can't ever handle the request
+func DefaultHTTPStatusPolicy() HTTPStatusPolicy {
+	return HTTPStatusPolicy{
+		http.StatusUnauthorized:                  RestrictedHTTPStatus,
+		http.StatusPaymentRequired:               RestrictedHTTPStatus,
+		http.StatusForbidden:                     RestrictedHTTPStatus,
+		http.StatusProxyAuthRequired:             RestrictedHTTPStatus,
+		http.StatusUnavailableForLegalReasons:    RestrictedHTTPStatus,
+		http.StatusNetworkAuthenticationRequired: RestrictedHTTPStatus,
+		http.StatusRequestTimeout:                RetryableHTTPStatus,
+		http.StatusTooManyRequests:               RetryableHTTPStatus,
+		http.StatusNotImplemented:                InvalidHTTPStatus,
+		http.StatusHTTPVersionNotSupported:       InvalidHTTPStatus,
+		http.StatusVariantAlsoNegotiates:         InvalidHTTPStatus,
+		http.StatusLoopDetected:                  InvalidHTTPStatus,
+		http.StatusNotExtended:                   InvalidHTTPStatus,
+	}
+}
+
+// Classify returns how the policy treats statusCode
+func (p HTTPStatusPolicy) Classify(statusCode int) HTTPStatusClass {
+	if class, ok := p[statusCode]; ok {
+		return class
+	}
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		return ValidHTTPStatus
+	case statusCode >= 500 && statusCode < 600:
+		return RetryableHTTPStatus
+	}
+	return InvalidHTTPStatus
+}
+
+// httpStatusReason explains why a destination with statusCode isn't harvested
+func httpStatusReason(statusCode int, class HTTPStatusClass) string {
+	switch class {
+	case RetryableHTTPStatus:
+		return fmt.Sprintf("Temporarily unavailable, HTTP Status Code %d", statusCode)
+	case RestrictedHTTPStatus:
+		return fmt.Sprintf("Restricted, HTTP Status Code %d", statusCode)
+	case InvalidHTTPStatus:
+		return fmt.Sprintf("Invalid HTTP Status Code %d", statusCode)
+	}
+	return ""
+}
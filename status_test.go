@@ -0,0 +1,149 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type StatusSuite struct {
+	suite.Suite
+	server *httptest.Server
+}
+
+func (suite *StatusSuite) SetupSuite() {
+	// each path is the status code to respond with, e.g. /404
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusCode, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			statusCode = http.StatusOK
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(statusCode)
+		fmt.Fprintf(w, "<html><head><title>%d</title></head></html>", statusCode)
+	}))
+}
+
+func (suite *StatusSuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+// harvest harvests the test server's URL for each status code, in order
+func (suite *StatusSuite) harvest(ch *ContentHarvester, statusCodes ...int) []*HarvestedResource {
+	var content []string
+	for _, statusCode := range statusCodes {
+		content = append(content, fmt.Sprintf("%s/%d", suite.server.URL, statusCode))
+	}
+	hrs := ch.HarvestResources(strings.Join(content, " "))
+	suite.Equal(len(hrs.Resources), len(statusCodes))
+	return hrs.Resources
+}
+
+func (suite *StatusSuite) TestDefaultPolicy() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	resources := suite.harvest(ch, 200, 203, 206, 404, 403, 429, 503)
+
+	for _, hr := range resources[:3] {
+		statusCode, class, reason := hr.HTTPStatus()
+		_, isDestValid := hr.IsValid()
+		suite.True(isDestValid, "%d should be a valid destination", statusCode)
+		suite.Equal(class, ValidHTTPStatus)
+		suite.Empty(reason)
+		suite.NotNil(hr.ResourceContent(), "%d should be inspected", statusCode)
+	}
+
+	gone := resources[3]
+	_, isDestValid := gone.IsValid()
+	suite.False(isDestValid)
+	isIgnored, _ := gone.IsIgnored()
+	suite.False(isIgnored, "Invalid destinations should not be flagged as ignored")
+	_, class, reason := gone.HTTPStatus()
+	suite.Equal(class, InvalidHTTPStatus)
+	suite.Equal(reason, "Invalid HTTP Status Code 404")
+	finalURL, _, _ := gone.GetURLs()
+	suite.Nil(finalURL)
+
+	paywall := resources[4]
+	isRestricted, reason := paywall.IsRestricted()
+	suite.True(isRestricted)
+	suite.Equal(reason, "Restricted, HTTP Status Code 403")
+	isRetryable, _ := paywall.IsRetryable()
+	suite.False(isRetryable)
+	finalURL, _, _ = paywall.GetURLs()
+	suite.Equal(finalURL.String(), suite.server.URL+"/403", "The restricted URL that was reached should be kept")
+	suite.Nil(paywall.ResourceContent())
+
+	for _, hr := range resources[5:] {
+		isRetryable, reason := hr.IsRetryable()
+		suite.True(isRetryable, reason)
+		_, isDestValid := hr.IsValid()
+		suite.False(isDestValid)
+	}
+}
+
+func (suite *StatusSuite) TestServerErrors() {
+	policy := DefaultHTTPStatusPolicy()
+	for _, statusCode := range []int{500, 502, 503, 504, 507, 599} {
+		suite.Equal(policy.Classify(statusCode), RetryableHTTPStatus, "%d should be retryable", statusCode)
+	}
+	for _, statusCode := range []int{501, 505, 506, 508, 510} {
+		suite.Equal(policy.Classify(statusCode), InvalidHTTPStatus, "%d should be invalid", statusCode)
+	}
+	suite.Equal(policy.Classify(511), RestrictedHTTPStatus)
+	suite.Equal(HTTPStatusPolicy{}.Classify(501), RetryableHTTPStatus, "Only the map's exceptions should change the 5xx range")
+	suite.Equal(HTTPStatusPolicy{}.Classify(600), InvalidHTTPStatus)
+}
+
+func (suite *StatusSuite) TestCustomPolicy() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	policy := DefaultHTTPStatusPolicy()
+	policy[http.StatusForbidden] = InvalidHTTPStatus
+	policy[http.StatusGone] = RestrictedHTTPStatus
+	policy[http.StatusPartialContent] = InvalidHTTPStatus
+	ch.SetHTTPStatusPolicy(policy)
+	resources := suite.harvest(ch, 403, 410, 206)
+
+	_, class, _ := resources[0].HTTPStatus()
+	suite.Equal(class, InvalidHTTPStatus)
+	isRestricted, _ := resources[1].IsRestricted()
+	suite.True(isRestricted)
+	_, class, _ = resources[2].HTTPStatus()
+	suite.Equal(class, InvalidHTTPStatus)
+}
+
+func (suite *StatusSuite) TestSerializerCallbacks() {
+	ch := MakeContentHarvester(nil, defaultIgnoreURLsRegExList, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	hrs := ch.HarvestResourcesFromSource(context.Background(), &ContentSource{Content: fmt.Sprintf("%[1]s/401 %[1]s/429 %[1]s/404", suite.server.URL)})
+
+	var restricted, retryable, invalid []*HarvestedResource
+	err := hrs.Serialize(HarvestedResourcesSerializer{
+		HandleInvalidURLDest: func(hr *HarvestedResource) { invalid = append(invalid, hr) },
+		HandleRestrictedURL:  func(hr *HarvestedResource) { restricted = append(restricted, hr) },
+	})
+	suite.NoError(err)
+	suite.Equal(len(restricted), 1)
+	suite.Equal(len(retryable), 0)
+	suite.Equal(len(invalid), 2, "Retryable destinations should go to HandleInvalidURLDest without HandleRetryableURL")
+
+	invalid = nil
+	err = hrs.Serialize(HarvestedResourcesSerializer{
+		HandleInvalidURLDest: func(hr *HarvestedResource) { invalid = append(invalid, hr) },
+		HandleRetryableURL:   func(hr *HarvestedResource) { retryable = append(retryable, hr) },
+	})
+	suite.NoError(err)
+	suite.Equal(len(retryable), 1)
+	suite.Equal(len(invalid), 2)
+}
+
+func TestStatusSuite(t *testing.T) {
+	suite.Run(t, new(StatusSuite))
+}
@@ -0,0 +1,74 @@
+package harvester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// fixtureSuite is embedded in the suites of adapters (tweets, e-mail, feeds and so on) whose fixtures
+// link to a test server: each fixture's SERVER placeholders are replaced with the server's URL. Every
+// page on the server is an HTML page titled with its path, except /missing which is 404 Not Found.
+type fixtureSuite struct {
+	suite.Suite
+	server  *httptest.Server
+	tempDir string
+}
+
+func (suite *fixtureSuite) SetupSuite() {
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><head><title>%s</title></head></html>", r.URL.Path)
+	}))
+}
+
+func (suite *fixtureSuite) TearDownSuite() {
+	suite.server.Close()
+	if len(suite.tempDir) > 0 {
+		os.RemoveAll(suite.tempDir)
+	}
+}
+
+// fixture reads a fixture with the test server's URL filled in
+func (suite *fixtureSuite) fixture(fileName string) string {
+	data, err := ioutil.ReadFile(fileName)
+	suite.NoError(err, "Fixture %s should exist", fileName)
+	return strings.Replace(string(data), "SERVER", suite.server.URL, -1)
+}
+
+// fixtureDir copies a directory of fixtures, with the test server's URL filled in, to a temporary
+// directory that's removed when the suite ends, and returns the copy's path
+func (suite *fixtureSuite) fixtureDir(dir string) string {
+	if len(suite.tempDir) == 0 {
+		tempDir, err := ioutil.TempDir("", "harvester-fixtures")
+		suite.Require().NoError(err)
+		suite.tempDir = tempDir
+	}
+	destDir := filepath.Join(suite.tempDir, filepath.Base(dir))
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(destDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(destPath, []byte(suite.fixture(path)), 0644)
+	})
+	suite.NoError(err, "Fixtures in %s should be copied", dir)
+	return destDir
+}
@@ -0,0 +1,279 @@
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Tweet is a single tweet parsed from Twitter API v1.1 or v2 JSON. URLs are the tweet's
+// entities.urls: each one's Text is the t.co link in the tweet's text and its URL is the
+// expanded_url, so harvesting starts at the destination rather than at t.co.
+type Tweet struct {
+	ID        string
+	Text      string
+	Author    string
+	CreatedAt time.Time
+	URLs      []*DiscoveredURL
+}
+
+// tweetJSON has the fields used from both v1.1 and v2 tweet objects
+type tweetJSON struct {
+	ID            interface{}        `json:"id"`
+	IDStr         string             `json:"id_str"`
+	Text          string             `json:"text"`
+	FullText      string             `json:"full_text"`
+	CreatedAt     string             `json:"created_at"`
+	AuthorID      string             `json:"author_id"`
+	User          *tweetUserJSON     `json:"user"`
+	Entities      tweetEntitiesJSON  `json:"entities"`
+	ExtendedTweet *tweetExtendedJSON `json:"extended_tweet"`
+}
+
+// tweetUserJSON is a v1.1 tweet's user, or a user in a v2 response's includes
+type tweetUserJSON struct {
+	ID         string `json:"id"`
+	ScreenName string `json:"screen_name"`
+	Username   string `json:"username"`
+}
+
+// tweetExtendedJSON is the full text of a v1.1 tweet longer than 140 characters
+type tweetExtendedJSON struct {
+	FullText string            `json:"full_text"`
+	Entities tweetEntitiesJSON `json:"entities"`
+}
+
+// tweetEntitiesJSON has the URL entities; v1.1 has indices and v2 has start and end, both counted
+// in Unicode code points
+type tweetEntitiesJSON struct {
+	URLs []struct {
+		URL         string `json:"url"`
+		ExpandedURL string `json:"expanded_url"`
+		UnwoundURL  string `json:"unwound_url"`
+		Indices     []int  `json:"indices"`
+		Start       *int   `json:"start"`
+		End         *int   `json:"end"`
+	} `json:"urls"`
+}
+
+// tweetResponseJSON is a v2 API response, whose data is a single tweet or a list of them
+type tweetResponseJSON struct {
+	Data     json.RawMessage `json:"data"`
+	Includes struct {
+		Users []*tweetUserJSON `json:"users"`
+	} `json:"includes"`
+}
+
+// tweetTimeFormats are the created_at formats of v1.1 and v2
+var tweetTimeFormats = []string{time.RubyDate, time.RFC3339}
+
+// ParseTweets reads Twitter API v1.1 or v2 JSON, which may be a single tweet, an array of tweets,
+// one tweet per line (JSONL) or v2 responses (with "data" and "includes"), in any of those forms
+func ParseTweets(reader io.Reader) ([]*Tweet, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []json.RawMessage
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := unmarshalJSON(data, &values); err != nil {
+			return nil, fmt.Errorf("unable to parse array of tweets: %v", err)
+		}
+	} else {
+		// a single value and JSONL are both a stream of values
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("unable to parse tweet %d: %v", len(values)+1, err)
+			}
+			values = append(values, value)
+		}
+	}
+
+	var result []*Tweet
+	for index, value := range values {
+		tweets, err := parseTweetValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse tweet %d: %v", index+1, err)
+		}
+		result = append(result, tweets...)
+	}
+	return result, nil
+}
+
+// parseTweetValue parses one JSON value, which is either a tweet or a v2 response
+func parseTweetValue(value json.RawMessage) ([]*Tweet, error) {
+	var response tweetResponseJSON
+	if err := unmarshalJSON(value, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Data) == 0 {
+		var tweet tweetJSON
+		if err := unmarshalJSON(value, &tweet); err != nil {
+			return nil, err
+		}
+		return []*Tweet{tweet.parse(nil)}, nil
+	}
+
+	users := make(map[string]string)
+	for _, user := range response.Includes.Users {
+		users[user.ID] = user.Username
+	}
+	var tweets []tweetJSON
+	if bytes.HasPrefix(bytes.TrimSpace(response.Data), []byte("[")) {
+		if err := unmarshalJSON(response.Data, &tweets); err != nil {
+			return nil, err
+		}
+	} else {
+		tweets = make([]tweetJSON, 1)
+		if err := unmarshalJSON(response.Data, &tweets[0]); err != nil {
+			return nil, err
+		}
+	}
+	var result []*Tweet
+	for _, tweet := range tweets {
+		result = append(result, tweet.parse(users))
+	}
+	return result, nil
+}
+
+// unmarshalJSON decodes numbers as json.Number since tweet IDs are too big for float64
+func unmarshalJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// parse converts either version of a tweet; users maps v2 author IDs to usernames
+func (t *tweetJSON) parse(users map[string]string) *Tweet {
+	result := new(Tweet)
+	result.ID = t.IDStr
+	if len(result.ID) == 0 && t.ID != nil {
+		result.ID = fmt.Sprint(t.ID)
+	}
+
+	result.Text = t.Text
+	entities := t.Entities
+	if len(t.FullText) > 0 {
+		result.Text = t.FullText
+	}
+	if t.ExtendedTweet != nil && len(t.ExtendedTweet.FullText) > 0 {
+		result.Text = t.ExtendedTweet.FullText
+		entities = t.ExtendedTweet.Entities
+	}
+
+	if t.User != nil {
+		result.Author = t.User.ScreenName
+	} else if len(t.AuthorID) > 0 {
+		result.Author = users[t.AuthorID]
+	}
+	for _, format := range tweetTimeFormats {
+		if createdAt, err := time.Parse(format, t.CreatedAt); err == nil {
+			result.CreatedAt = createdAt
+			break
+		}
+	}
+
+	for _, entity := range entities.URLs {
+		discovered := &DiscoveredURL{Text: entity.URL, URL: entity.ExpandedURL, Offset: -1, EmbedStyle: PlainTextEmbed}
+		if len(entity.UnwoundURL) > 0 {
+			discovered.URL = entity.UnwoundURL
+		}
+		if len(discovered.URL) == 0 {
+			discovered.URL = entity.URL
+		}
+
+		start, end := -1, -1
+		if len(entity.Indices) == 2 {
+			start, end = entity.Indices[0], entity.Indices[1]
+		} else if entity.Start != nil && entity.End != nil {
+			start, end = *entity.Start, *entity.End
+		}
+		discovered.Offset = codePointsToByteOffset(result.Text, start)
+		if discovered.Offset < 0 || codePointsToByteOffset(result.Text, end) < 0 {
+			discovered.Offset = strings.Index(result.Text, entity.URL)
+		} else {
+			discovered.Text = result.Text[discovered.Offset:codePointsToByteOffset(result.Text, end)]
+		}
+		result.URLs = append(result.URLs, discovered)
+	}
+	return result
+}
+
+// codePointsToByteOffset converts an offset counted in Unicode code points, as tweet entity
+// indices are, to a byte offset in text; it's -1 if the offset is outside the text
+func codePointsToByteOffset(text string, codePoints int) int {
+	if codePoints < 0 {
+		return -1
+	}
+	offset := 0
+	for i := 0; i < codePoints; i++ {
+		if offset >= len(text) {
+			return -1
+		}
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// SourceURL returns the tweet's web address
+func (t *Tweet) SourceURL() *url.URL {
+	author := t.Author
+	if len(author) == 0 {
+		author = "i/web"
+	}
+	result, _ := url.Parse(fmt.Sprintf("https://twitter.com/%s/status/%s", author, t.ID))
+	return result
+}
+
+// ContentSource returns the tweet's text for harvesting, with its provenance and with its
+// entities as its only links
+func (t *Tweet) ContentSource() *ContentSource {
+	return &ContentSource{
+		Content: t.Text,
+		Format:  PlainTextContent,
+		Provenance: &Provenance{
+			Kind:       TweetProvenance,
+			SourceID:   t.ID,
+			Author:     t.Author,
+			Timestamp:  t.CreatedAt,
+			SourceURL:  t.SourceURL(),
+			EmbedStyle: PlainTextEmbed,
+		},
+		Links:     t.URLs,
+		LinksOnly: true,
+	}
+}
+
+// HarvestTweets parses Twitter API JSON (see ParseTweets) and harvests each tweet's URLs, starting
+// at their expanded_url so no requests are made to t.co. The ignore and clean rules still apply to
+// each destination. It returns one HarvestedResources per tweet, including tweets without any URLs,
+// and stops at the first tweet that's cancelled.
+func (h *ContentHarvester) HarvestTweets(ctx context.Context, reader io.Reader) ([]*HarvestedResources, error) {
+	tweets, err := ParseTweets(reader)
+	if err != nil {
+		return nil, err
+	}
+	var result []*HarvestedResources
+	for _, tweet := range tweets {
+		harvested := h.HarvestResourcesFromSource(ctx, tweet.ContentSource())
+		result = append(result, harvested)
+		if isCancelled, _ := harvested.IsCancelled(); isCancelled {
+			break
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,90 @@
+package harvester
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TwitterSuite struct {
+	fixtureSuite
+}
+
+func (suite *TwitterSuite) TestParseV1() {
+	tweets, err := ParseTweets(strings.NewReader(suite.fixture("testdata/twitter/v1.1-extended.json")))
+	suite.NoError(err)
+	suite.Equal(len(tweets), 1)
+
+	tweet := tweets[0]
+	suite.Equal(tweet.ID, "1002588441512452098")
+	suite.Equal(tweet.Author, "example")
+	suite.True(tweet.CreatedAt.Equal(time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)))
+	suite.True(strings.HasSuffix(tweet.Text, "again"), "The extended tweet's full text should be used")
+	suite.Equal(len(tweet.URLs), 3, "The extended tweet's entities should be used")
+	suite.Equal(tweet.URLs[0].URL, suite.server.URL+"/article?utm_source=twitter")
+	suite.Equal(tweet.URLs[0].Text, "https://t.co/short1")
+	suite.Equal(tweet.URLs[0].Offset, 17, "Code point indices should be converted to byte offsets")
+	suite.Equal(tweet.SourceURL().String(), "https://twitter.com/example/status/1002588441512452098")
+}
+
+func (suite *TwitterSuite) TestParseV2() {
+	tweets, err := ParseTweets(strings.NewReader(suite.fixture("testdata/twitter/v2-responses.jsonl")))
+	suite.NoError(err)
+	suite.Equal(len(tweets), 3, "Each line's data may be a single tweet or an array of them")
+
+	suite.Equal(tweets[0].ID, "2001")
+	suite.Equal(tweets[0].Author, "example_v2", "The author should come from the response's includes")
+	suite.Equal(tweets[0].CreatedAt, time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC))
+	suite.Equal(tweets[0].URLs[0].Text, "https://t.co/v2first")
+	suite.Equal(tweets[0].URLs[0].Offset, 11, "Emoji are a single code point but four bytes")
+	suite.Empty(tweets[1].URLs)
+	suite.Equal(tweets[1].SourceURL().String(), "https://twitter.com/i/web/status/2002")
+	suite.Equal(tweets[2].URLs[0].URL, suite.server.URL+"/unwound", "The unwound URL should be preferred")
+
+	array := "[" + strings.Replace(strings.TrimSpace(suite.fixture("testdata/twitter/v2-responses.jsonl")), "\n", ",", -1) + "]"
+	tweets, err = ParseTweets(strings.NewReader(array))
+	suite.NoError(err)
+	suite.Equal(len(tweets), 3, "An array of responses should be parsed too")
+
+	_, err = ParseTweets(strings.NewReader(`{"id": "1", "text": "unterminated`))
+	suite.Error(err)
+}
+
+func (suite *TwitterSuite) TestHarvestTweets() {
+	ignoreRule := ignoreURLsRegExList{regexp.MustCompile(`/ignored$`)}
+	ch := MakeContentHarvester(nil, ignoreRule, defaultCleanURLsRegExList, false)
+	ch.SetFetcher(suite.server.Client())
+	harvested, err := ch.HarvestTweets(context.Background(), strings.NewReader(suite.fixture("testdata/twitter/v1.1-extended.json")))
+	suite.NoError(err)
+	suite.Equal(len(harvested), 1)
+
+	hrs := harvested[0]
+	suite.Equal(hrs.Provenance.Kind, TweetProvenance)
+	suite.Equal(hrs.Provenance.SourceID, "1002588441512452098")
+	suite.Equal(hrs.Provenance.Author, "example")
+	suite.Equal(len(hrs.Resources), 2, "Entities with the same expanded URL should be harvested once")
+
+	article := hrs.Resources[0]
+	suite.Equal(article.OriginalURLText(), suite.server.URL+"/article?utm_source=twitter", "Harvesting should start at the expanded URL, not t.co")
+	suite.Empty(article.RedirectChain(), "There should be no t.co hop")
+	isCleaned, cleanedURL := article.IsCleaned()
+	suite.True(isCleaned, "The clean rules should still apply")
+	suite.Equal(cleanedURL.String(), suite.server.URL+"/article")
+	suite.Equal(len(article.Occurrences()), 2)
+	suite.Equal(article.Occurrences()[1].Line, 1)
+	suite.Equal(article.Provenance().SourceURL.String(), "https://twitter.com/example/status/1002588441512452098")
+
+	isIgnored, _ := hrs.Resources[1].IsIgnored()
+	suite.True(isIgnored, "The ignore rules should still apply")
+
+	suite.Equal(hrs.RewriteContent(RewritePolicy{StripIgnored: true}),
+		"Café ☕ review "+suite.server.URL+"/article and  plus "+suite.server.URL+"/article again", "Offsets should allow rewriting the tweet")
+}
+
+func TestTwitterSuite(t *testing.T) {
+	suite.Run(t, new(TwitterSuite))
+}
@@ -0,0 +1,131 @@
+package harvester
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// CleanedURLVerification decides how a cleaned URL is checked before it's used as the finalURL;
+// removing query parameters might break a URL so when a check fails the resolvedURL is used instead
+type CleanedURLVerification int
+
+const (
+	// DontVerifyCleanedURL trusts that cleaned URLs work (no extra requests are made)
+	DontVerifyCleanedURL CleanedURLVerification = iota
+
+	// VerifyCleanedURLStatus fetches the cleaned URL and requires the same HTTP status as the uncleaned URL
+	VerifyCleanedURLStatus
+
+	// VerifyCleanedURLContent also requires the cleaned URL's content fingerprint to match the uncleaned
+	// content; for HTML the fingerprint is the page's <title> (since markup often has tokens, timestamps
+	// and the like that change on every request) and for anything else it's a hash of the entire body.
+	// Content that can't be fingerprinted, such as a page without a title, fails verification.
+	VerifyCleanedURLContent
+)
+
+// verifyCleanedURL fetches the cleaned URL and compares it to the uncleaned response, returning
+// true if the cleaned URL can be used or false with the reason it can't
+func verifyCleanedURL(ctx context.Context, h *ContentHarvester, cleanedURL *url.URL, statusCode int, fingerprint string) (bool, string) {
+	resp, err := h.fetch(ctx, cleanedURL.String())
+	if err != nil {
+		return false, fmt.Sprintf("Cleaned URL could not be retrieved: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != statusCode {
+		return false, fmt.Sprintf("Cleaned URL returned HTTP Status Code %d instead of %d", resp.StatusCode, statusCode)
+	}
+
+	if h.cleanedURLVerification == VerifyCleanedURLContent {
+		if len(fingerprint) == 0 {
+			return false, "Uncleaned content could not be fingerprinted"
+		}
+		var cleanedFingerprint string
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if mediaType == "text/html" {
+			doc, parseError := html.Parse(&contextReader{ctx, resp.Body})
+			if parseError != nil {
+				return false, fmt.Sprintf("Cleaned URL content could not be parsed: %v", parseError)
+			}
+			cleanedFingerprint = htmlFingerprint(doc)
+		} else {
+			cleanedFingerprint = bodyFingerprint(&contextReader{ctx, resp.Body})
+		}
+		if len(cleanedFingerprint) == 0 {
+			return false, "Cleaned URL content could not be fingerprinted"
+		}
+		if cleanedFingerprint != fingerprint {
+			return false, "Cleaned URL content differs from uncleaned content"
+		}
+	}
+
+	return true, ""
+}
+
+// contentFingerprint returns the fingerprint of harvested content, using either the parsed
+// HTML document or the downloaded file; it's empty if neither can be fingerprinted
+func contentFingerprint(doc *html.Node, content *HarvestedResourceContent) string {
+	if doc != nil {
+		return htmlFingerprint(doc)
+	}
+	if content != nil && content.Downloaded != nil && len(content.Downloaded.DestPath) > 0 {
+		file, err := os.Open(content.Downloaded.DestPath)
+		if err == nil {
+			defer file.Close()
+			return bodyFingerprint(file)
+		}
+	}
+	return ""
+}
+
+// htmlFingerprint identifies an HTML page by its whitespace-normalized <title>; it's empty if
+// the page has no title
+func htmlFingerprint(doc *html.Node) string {
+	title := strings.Join(strings.Fields(findTitle(doc)), " ")
+	if len(title) == 0 {
+		return ""
+	}
+	return "title:" + title
+}
+
+// bodyFingerprint identifies content by the SHA-256 hash of all its bytes
+func bodyFingerprint(body io.Reader) string {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return ""
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// findTitle returns the text of the document's first <title> element
+func findTitle(doc *html.Node) string {
+	var title string
+	var found bool
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if found {
+			return
+		}
+		if n.Type == html.ElementNode && strings.EqualFold(n.Data, "title") {
+			found = true
+			if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+				title = n.FirstChild.Data
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	return title
+}